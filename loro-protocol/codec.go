@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"bytes"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// WebSocket subprotocols naming the codec a client wants frames in
+const (
+	SubprotocolJSON    = "loro.json"
+	SubprotocolMsgpack = "loro.msgpack"
+)
+
+/*
+Codec turns envelopes into websocket frames and back. Binary codecs are sent
+as binary websocket messages and the others as text. Marshal stamps the
+envelope with the current version.
+*/
+type Codec interface {
+	Subprotocol() string
+	Binary() bool
+	Marshal(e Envelope) ([]byte, error)
+	Unmarshal(data []byte) (*Envelope, error)
+}
+
+var (
+	// JSON is the default codec, used when the client asks for no subprotocol
+	JSON Codec = jsonCodec{}
+	// Msgpack is a compact binary codec for large chats
+	Msgpack Codec = msgpackCodec{}
+)
+
+// Subprotocols lists the codecs a server accepts, preferred first
+var Subprotocols = []string{SubprotocolMsgpack, SubprotocolJSON}
+
+// CodecFor returns the codec of the subprotocol agreed at the handshake
+func CodecFor(subprotocol string) Codec {
+	if subprotocol == SubprotocolMsgpack {
+		return Msgpack
+	}
+	return JSON
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string { return SubprotocolJSON }
+
+func (jsonCodec) Binary() bool { return false }
+
+func (jsonCodec) Marshal(e Envelope) ([]byte, error) { return Marshal(e) }
+
+func (jsonCodec) Unmarshal(data []byte) (*Envelope, error) { return Unmarshal(data) }
+
+// msgpackCodec reuses the json tags so both codecs name fields alike
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string { return SubprotocolMsgpack }
+
+func (msgpackCodec) Binary() bool { return true }
+
+func (msgpackCodec) Marshal(e Envelope) ([]byte, error) {
+	e.V = Version
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(&e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte) (*Envelope, error) {
+	e := &Envelope{}
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
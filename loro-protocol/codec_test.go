@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sampleFrame() Envelope {
+	id, chatID, seq := 4821, 17, int64(4410)
+	clientID := "9f1c2a4be07d4d3c8a51f0e6b2c47d90"
+	sender, body := "jaoks", strings.Repeat("see you at the standup tomorrow ", 4)
+	createdAt := time.Date(2024, 11, 3, 18, 4, 5, 0, time.UTC)
+	return Envelope{Type: TypeMessage, Message: &Message{
+		ID:        &id,
+		ClientID:  &clientID,
+		ChatID:    &chatID,
+		Seq:       &seq,
+		Sender:    &sender,
+		Body:      &body,
+		CreatedAt: &createdAt,
+	}}
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{JSON, Msgpack} {
+		frame := sampleFrame()
+		data, err := codec.Marshal(frame)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.Subprotocol(), err)
+		}
+		got, err := codec.Unmarshal(data)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.Subprotocol(), err)
+		}
+
+		frame.V = Version
+		if !got.Message.CreatedAt.Equal(*frame.Message.CreatedAt) {
+			t.Fatalf("%s: created at %v, want %v", codec.Subprotocol(), got.Message.CreatedAt, frame.Message.CreatedAt)
+		}
+		// only the instant matters, not the location it was decoded in
+		got.Message.CreatedAt = frame.Message.CreatedAt
+		if !reflect.DeepEqual(*got, frame) {
+			t.Fatalf("%s: got %+v, want %+v", codec.Subprotocol(), *got, frame)
+		}
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	if CodecFor(SubprotocolMsgpack) != Msgpack {
+		t.Fatal("msgpack subprotocol should pick msgpack")
+	}
+	// no subprotocol keeps json
+	if CodecFor("") != JSON {
+		t.Fatal("no subprotocol should pick json")
+	}
+}
+
+func benchmarkMarshal(b *testing.B, codec Codec) {
+	frame := sampleFrame()
+	b.ReportAllocs()
+	var data []byte
+	for i := 0; i < b.N; i++ {
+		var err error
+		if data, err = codec.Marshal(frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(data)), "bytes/frame")
+}
+
+func benchmarkUnmarshal(b *testing.B, codec Codec) {
+	data, _ := codec.Marshal(sampleFrame())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.Unmarshal(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalJSON(b *testing.B)      { benchmarkMarshal(b, JSON) }
+func BenchmarkMarshalMsgpack(b *testing.B)   { benchmarkMarshal(b, Msgpack) }
+func BenchmarkUnmarshalJSON(b *testing.B)    { benchmarkUnmarshal(b, JSON) }
+func BenchmarkUnmarshalMsgpack(b *testing.B) { benchmarkUnmarshal(b, Msgpack) }
@@ -0,0 +1,158 @@
+/*
+Package protocol defines the frames loro clients and servers exchange over
+the websocket. Every frame is an envelope carrying the protocol version, its
+type and the payload of that type. Receivers ignore frame types they do not
+know, so either side can be newer than the other.
+*/
+package protocol
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+const (
+	// Version is the newest protocol version. Version 0 is the flat message
+	// frame spoken before envelopes existed.
+	Version = 1
+	// VersionParam is the /ws/join query parameter a client asks for a version with
+	VersionParam = "v"
+	// VersionHeader carries the version the server chose in the handshake response
+	VersionHeader = "X-Loro-Protocol"
+	// PingIntervalHeader carries how often the server pings, in whole seconds
+	PingIntervalHeader = "X-Loro-Ping-Interval"
+)
+
+// Frame types
+const (
+	// chat message, sent by clients and relayed to the members once stored
+	TypeMessage = "message"
+	// tells the sender its message was stored
+	TypeAck = "ack"
+	// answers a frame that could not be handled
+	TypeError = "error"
+	// sent by clients to move their read cursor to a message
+	TypeRead = "read"
+	// sent to members when someone moved their read cursor
+	TypeReceipt = "receipt"
+	// ephemeral, relayed to the other members and never stored
+	TypeTyping = "typing"
+	// sent to the contacts of a user who came online or went offline
+	TypePresence = "presence"
+	// sent by a reconnecting client with the last sequence it has of each chat
+	TypeResume = "resume"
+	// ends the replay of a resume, live frames follow
+	TypeResumed = "resumed"
+	// notices about a chat such as members joining or leaving, not stored
+	TypeSystem = "system"
+	// sent by clients to change the body of their message, relayed to the members once stored
+	TypeEdit = "edit"
+	// sent by clients to delete a message for themselves or for everyone, relayed once stored
+	TypeRetract = "retract"
+	// sent by clients to toggle their reaction on a message, relayed with the new counts
+	TypeReaction = "reaction"
+	// sent to the members mentioned in a group message, even when they muted the chat
+	TypeMention = "mention"
+	// sent by clients to pin or unpin a message of a chat, relayed to the members once stored
+	TypePin = "pin"
+	// sent to a member when the number of messages they have not read in a chat changed
+	TypeUnread = "unread"
+	// sent to the sessions of a user who blocked or unblocked someone
+	TypeBlock = "block"
+	// sent to both users when a contact request is sent, accepted or declined, or a contact removed
+	TypeContact = "contact"
+)
+
+// Envelope wraps every frame, only the payload matching Type is set
+type Envelope struct {
+	V        int       `json:"v"`
+	Type     string    `json:"type"`
+	Message  *Message  `json:"message,omitempty"`
+	Ack      *Ack      `json:"ack,omitempty"`
+	Error    *Error    `json:"error,omitempty"`
+	Read     *Read     `json:"read,omitempty"`
+	Receipt  *Receipt  `json:"receipt,omitempty"`
+	Typing   *Typing   `json:"typing,omitempty"`
+	Presence *Presence `json:"presence,omitempty"`
+	Resume   *Resume   `json:"resume,omitempty"`
+	Resumed  *Resumed  `json:"resumed,omitempty"`
+	System   *System   `json:"system,omitempty"`
+	Edit     *Edit     `json:"edit,omitempty"`
+	Retract  *Retract  `json:"retract,omitempty"`
+	Reaction *Reaction `json:"reaction,omitempty"`
+	Mention  *Mention  `json:"mention,omitempty"`
+	Pin      *Pin      `json:"pin,omitempty"`
+	Unread   *Unread   `json:"unread,omitempty"`
+	Block    *Block    `json:"block,omitempty"`
+	Contact  *Contact  `json:"contact,omitempty"`
+}
+
+// Known reports whether the type is one this version understands and its payload is set
+func (e *Envelope) Known() bool {
+	switch e.Type {
+	case TypeMessage:
+		return e.Message != nil
+	case TypeAck:
+		return e.Ack != nil
+	case TypeError:
+		return e.Error != nil
+	case TypeRead:
+		return e.Read != nil
+	case TypeReceipt:
+		return e.Receipt != nil
+	case TypeTyping:
+		return e.Typing != nil
+	case TypePresence:
+		return e.Presence != nil
+	case TypeResume:
+		return e.Resume != nil
+	case TypeResumed:
+		return e.Resumed != nil
+	case TypeSystem:
+		return e.System != nil
+	case TypeEdit:
+		return e.Edit != nil
+	case TypeRetract:
+		return e.Retract != nil
+	case TypeReaction:
+		return e.Reaction != nil
+	case TypeMention:
+		return e.Mention != nil
+	case TypePin:
+		return e.Pin != nil
+	case TypeUnread:
+		return e.Unread != nil
+	case TypeBlock:
+		return e.Block != nil
+	case TypeContact:
+		return e.Contact != nil
+	}
+	return false
+}
+
+// Marshal encodes the envelope stamped with the current version
+func Marshal(e Envelope) ([]byte, error) {
+	e.V = Version
+	return json.Marshal(e)
+}
+
+func Unmarshal(data []byte) (*Envelope, error) {
+	e := &Envelope{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+/*
+Negotiate picks the version to speak with a client that asked for the given
+one. A client asking for nothing speaks version 0 and one newer than the
+server gets the newest the server has.
+*/
+func Negotiate(requested string) int {
+	v, err := strconv.Atoi(requested)
+	if err != nil || v < 0 {
+		return 0
+	}
+	return min(v, Version)
+}
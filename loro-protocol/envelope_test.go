@@ -0,0 +1,27 @@
+package protocol
+
+import "testing"
+
+func TestUnknownFramesAreNotKnown(t *testing.T) {
+	e, err := Unmarshal([]byte(`{"v":9,"type":"hologram","hologram":{"x":1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Known() {
+		t.Fatalf("frame of type %s should not be known", e.Type)
+	}
+
+	// a known type without its payload is not usable either
+	if (&Envelope{Type: TypeMessage}).Known() {
+		t.Fatal("message frame without message should not be known")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]int{"": 0, "abc": 0, "-1": 0, "0": 0, "1": 1, "7": Version}
+	for requested, want := range cases {
+		if got := Negotiate(requested); got != want {
+			t.Errorf("Negotiate(%q) = %d, want %d", requested, got, want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package protocol
+
+import "time"
+
+// States of a typing frame
+const (
+	TypingStart = "start"
+	TypingStop  = "stop"
+)
+
+// States of a contact frame
+const (
+	ContactRequested = "requested"
+	ContactAccepted  = "accepted"
+	ContactDeclined  = "declined"
+	ContactRemoved   = "removed"
+)
+
+// Error codes sent in error frames
+const (
+	ErrCodeInvalid   = "invalid"
+	ErrCodeNotMember = "not_member"
+	ErrCodeNotFound  = "not_found"
+	ErrCodeInternal  = "internal"
+	ErrCodeForbidden = "forbidden"
+)
+
+/*
+Message is a chat message. Clients send a body with a chat, or with a
+receiver to start a direct chat, and a client id that makes retries safe. The
+server fills in the rest before relaying it.
+*/
+type Message struct {
+	ID        *int       `json:"id,omitempty"`
+	ClientID  *string    `json:"clientId,omitempty"`
+	ChatID    *int       `json:"chatId,omitempty"`
+	Seq       *int64     `json:"seq,omitempty"`
+	Sender    *string    `json:"sender,omitempty"`
+	Receiver  *string    `json:"receiver,omitempty"`
+	Body      *string    `json:"body,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	// set on a deleted message, which comes without body
+	DeletedAt *time.Time      `json:"deleted_at,omitempty"`
+	Reactions []ReactionCount `json:"reactions,omitempty"`
+	// the message this one answers, the server adds a quote of it
+	ReplyTo *int   `json:"replyTo,omitempty"`
+	Quote   *Quote `json:"quote,omitempty"`
+	// members named with @username in a group message, filled in by the server
+	Mentions []string `json:"mentions,omitempty"`
+	// a file uploaded to the chat before, clients only send its id
+	Attachment *Attachment `json:"attachment,omitempty"`
+}
+
+// Attachment describes a file sent with a message, its content is downloaded apart
+type Attachment struct {
+	ID          int    `json:"id"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+}
+
+// Quote is the start of the message a reply answers, without body once deleted
+type Quote struct {
+	ID      int     `json:"id"`
+	Sender  *string `json:"sender,omitempty"`
+	Body    *string `json:"body,omitempty"`
+	Deleted bool    `json:"deleted,omitempty"`
+}
+
+type Ack struct {
+	ID        int       `json:"id"`
+	ClientID  *string   `json:"clientId,omitempty"`
+	ChatID    int       `json:"chatId"`
+	Seq       int64     `json:"seq"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Error names the frame it answers by client id or chat when it had one
+type Error struct {
+	ClientID *string `json:"clientId,omitempty"`
+	ChatID   *int    `json:"chatId,omitempty"`
+	Code     string  `json:"code"`
+	Message  string  `json:"message"`
+}
+
+type Read struct {
+	ChatID    int `json:"chatId"`
+	MessageID int `json:"messageId"`
+}
+
+type Receipt struct {
+	ChatID    int       `json:"chatId"`
+	MessageID int       `json:"messageId"`
+	Username  string    `json:"username"`
+	ReadAt    time.Time `json:"read_at"`
+}
+
+// Typing is sent by clients without username, the server fills it in
+type Typing struct {
+	ChatID   int    `json:"chatId"`
+	Username string `json:"username,omitempty"`
+	State    string `json:"state"`
+}
+
+type Presence struct {
+	Username   string     `json:"username"`
+	Online     bool       `json:"online"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
+
+type Resume struct {
+	Cursors []SeqCursor `json:"cursors"`
+}
+
+// SeqCursor is the last message sequence a client has of a chat
+type SeqCursor struct {
+	ChatID int   `json:"chatId"`
+	Seq    int64 `json:"seq"`
+}
+
+// Resumed lists the chats whose replay was cut short, the client reloads them
+type Resumed struct {
+	Truncated []int `json:"truncated"`
+}
+
+type System struct {
+	ChatID int    `json:"chatId"`
+	Actor  string `json:"actor"`
+	Body   string `json:"body"`
+}
+
+// Edit replaces the body of a message, the server fills in when it happened
+type Edit struct {
+	ChatID    int        `json:"chatId"`
+	MessageID int        `json:"messageId"`
+	Body      string     `json:"body"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+}
+
+/*
+Retract deletes a message. For everyone it is relayed to every member and
+only the sender may do it, otherwise it only reaches the sessions of the user
+who hid it.
+*/
+type Retract struct {
+	ChatID      int        `json:"chatId"`
+	MessageID   int        `json:"messageId"`
+	ForEveryone bool       `json:"forEveryone"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+/*
+Reaction toggles the emoji of the user on a message, sending the emoji the
+user already has removes it and any other replaces it. The server relays it
+with who reacted and the counts once stored, an empty emoji then means the
+reaction was removed.
+*/
+type Reaction struct {
+	ChatID    int             `json:"chatId"`
+	MessageID int             `json:"messageId"`
+	Emoji     string          `json:"emoji"`
+	Username  string          `json:"username,omitempty"`
+	Reactions []ReactionCount `json:"reactions,omitempty"`
+}
+
+// ReactionCount tells how many members reacted to a message with the emoji
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// Mention tells a member a message of a group named them
+type Mention struct {
+	ChatID    int       `json:"chatId"`
+	MessageID int       `json:"messageId"`
+	Seq       int64     `json:"seq"`
+	Sender    string    `json:"sender"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+/*
+Pin pins a message to the top of its chat or unpins it. The server relays it
+with who did it and, for a pin, the sender and body of the message.
+*/
+type Pin struct {
+	ChatID    int        `json:"chatId"`
+	MessageID int        `json:"messageId"`
+	Pinned    bool       `json:"pinned"`
+	Username  string     `json:"username,omitempty"`
+	Sender    *string    `json:"sender,omitempty"`
+	Body      *string    `json:"body,omitempty"`
+	PinnedAt  *time.Time `json:"pinned_at,omitempty"`
+}
+
+// Unread is how many messages of others the member has not read in the chat
+type Unread struct {
+	ChatID int `json:"chatId"`
+	Count  int `json:"count"`
+}
+
+// Block tells a user's sessions they blocked the user or unblocked them
+type Block struct {
+	Username string `json:"username"`
+	Blocked  bool   `json:"blocked"`
+}
+
+/*
+Contact tells both users what became of a contact request between them: From
+asked To, and To accepted or declined, or one removed the other as a contact.
+*/
+type Contact struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status string `json:"status"`
+}
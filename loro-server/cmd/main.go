@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 
@@ -55,17 +60,146 @@ func main() {
 
 	protected.Use(utils.CustomMiddleware)
 
-	// curl localhost:8081/api/chats --cookie "token=<YOUR_TOKEN>"
+	// filter is inbox by default, or archived, hidden or all
+	// curl "localhost:8081/api/chats?filter=archived" --cookie "token=<YOUR_TOKEN>"
 	protected.GET("/chats", chatController.GetChats)
 
-	// curl "localhost:8081/api/:chatID/messages?limit=5&offset=0" --cookie "token=<YOUR_TOKEN>"
+	// chat, sender, from and to are optional, the next_cursor answered gives the next page
+	// curl "localhost:8081/api/search?q=dinner&sender=ana&from=2024-01-01&cursor=<NEXT_CURSOR>" --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/search", chatController.Search)
+
+	// unread mentions of the user in groups
+	// curl localhost:8081/api/mentions --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/mentions", chatController.GetMentions)
+
+	// newest first, next_cursor gives the older page; before=<seq> or after=<seq> start elsewhere
+	// curl "localhost:8081/api/:chatID/messages?limit=5&cursor=<NEXT_CURSOR>" --cookie "token=<YOUR_TOKEN>"
 	protected.GET("/:chatID/messages", chatController.GetMessages)
 
+	// curl -X PUT -H 'Content-Type: application/json' -d '{"body":"hi again"}' localhost:8081/api/:chatID/messages/:messageID --cookie "token=<YOUR_TOKEN>"
+	protected.PUT("/:chatID/messages/:messageID", chatController.EditMessage)
+
+	// curl -X DELETE "localhost:8081/api/:chatID/messages/:messageID?for=everyone" --cookie "token=<YOUR_TOKEN>"
+	protected.DELETE("/:chatID/messages/:messageID", chatController.DeleteMessage)
+
+	// the same emoji again removes the reaction, another one replaces it
+	// curl -X POST -H 'Content-Type: application/json' -d '{"emoji":"👍"}' localhost:8081/api/:chatID/messages/:messageID/reactions --cookie "token=<YOUR_TOKEN>"
+	protected.POST("/:chatID/messages/:messageID/reactions", chatController.ToggleReaction)
+
+	// curl "localhost:8081/api/:chatID/messages/:messageID/thread?limit=20&offset=0" --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/:chatID/messages/:messageID/thread", chatController.GetThread)
+
+	// curl localhost:8081/api/:chatID/messages/:messageID/revisions --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/:chatID/messages/:messageID/revisions", chatController.GetRevisions)
+
+	// the id answered goes in the attachment of the message sent next
+	// curl -F "file=@photo.png" localhost:8081/api/:chatID/attachments --cookie "token=<YOUR_TOKEN>"
+	protected.POST("/:chatID/attachments", chatController.Upload)
+
+	// curl -OJ localhost:8081/api/attachments/:attachmentID --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/attachments/:attachmentID", chatController.Download)
+
+	// curl localhost:8081/api/:chatID/pins --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/:chatID/pins", chatController.GetPins)
+
+	// curl -X POST -H 'Content-Type: application/json' -d '{"message_id":1}' localhost:8081/api/:chatID/pins --cookie "token=<YOUR_TOKEN>"
+	protected.POST("/:chatID/pins", chatController.PinMessage)
+
+	// curl -X DELETE localhost:8081/api/:chatID/pins/:messageID --cookie "token=<YOUR_TOKEN>"
+	protected.DELETE("/:chatID/pins/:messageID", chatController.UnpinMessage)
+
+	// fields left out stay as they are, a muted_until in the past unmutes
+	// archived and hidden chats come back with the next message unless muted
+	// curl -X PATCH -H 'Content-Type: application/json' -d '{"archived":true, "muted_until":"2030-01-01T00:00:00Z"}' localhost:8081/api/:chatID/state --cookie "token=<YOUR_TOKEN>"
+	protected.PATCH("/:chatID/state", chatController.SetChatState)
+
+	// curl -X POST -H 'Content-Type: application/json' -d '{"message_id":1}' localhost:8081/api/:chatID/read --cookie "token=<YOUR_TOKEN>"
+	protected.POST("/:chatID/read", chatController.MarkRead)
+
+	// curl localhost:8081/api/:chatID/receipts --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/:chatID/receipts", chatController.GetReceipts)
+
+	// curl "localhost:8081/api/presence?usernames=jaoks,mike" --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/presence", chatController.GetPresence)
+
+	// contacts with their nickname and presence
+	// curl localhost:8081/api/contacts --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/contacts", chatController.GetContacts)
+
+	// curl -X PUT -H 'Content-Type: application/json' -d '{"nickname":"Mike"}' localhost:8081/api/contacts/:username --cookie "token=<YOUR_TOKEN>"
+	protected.PUT("/contacts/:username", chatController.SetNickname)
+
+	// curl -X DELETE localhost:8081/api/contacts/:username --cookie "token=<YOUR_TOKEN>"
+	protected.DELETE("/contacts/:username", chatController.RemoveContact)
+
+	// incoming and outgoing contact requests
+	// curl localhost:8081/api/contacts/requests --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/contacts/requests", chatController.GetContactRequests)
+
+	// asking someone who asked first accepts their request
+	// curl -X POST -H 'Content-Type: application/json' -d '{"username":"sdtc"}' localhost:8081/api/contacts/requests --cookie "token=<YOUR_TOKEN>"
+	protected.POST("/contacts/requests", chatController.RequestContact)
+
+	// curl -X POST localhost:8081/api/contacts/requests/:username/accept --cookie "token=<YOUR_TOKEN>"
+	protected.POST("/contacts/requests/:username/accept", chatController.AcceptContact)
+
+	// declines a request received or withdraws one sent
+	// curl -X DELETE localhost:8081/api/contacts/requests/:username --cookie "token=<YOUR_TOKEN>"
+	protected.DELETE("/contacts/requests/:username", chatController.DeclineContact)
+
+	// curl localhost:8081/api/blocks --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/blocks", chatController.GetBlocks)
+
+	// blocked users cannot write to the user in a direct chat nor see them online
+	// curl -X POST -H 'Content-Type: application/json' -d '{"username":"sdtc"}' localhost:8081/api/blocks --cookie "token=<YOUR_TOKEN>"
+	protected.POST("/blocks", chatController.Block)
+
+	// curl -X DELETE localhost:8081/api/blocks/:username --cookie "token=<YOUR_TOKEN>"
+	protected.DELETE("/blocks/:username", chatController.Unblock)
+
+	// curl localhost:8081/api/sessions --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/sessions", chatController.GetSessions)
+
+	// only users listed in STATS_ADMINS
+	// curl localhost:8081/api/stats --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/stats", chatController.GetStats)
+
+	// curl -X POST -H 'Content-Type: application/json' -d '{"name":"friends", "members":["sdtc"]}' localhost:8081/api/groups --cookie "token=<YOUR_TOKEN>"
+	protected.POST("/groups", chatController.CreateGroup)
+
+	// curl localhost:8081/api/:chatID/members --cookie "token=<YOUR_TOKEN>"
+	protected.GET("/:chatID/members", chatController.GetMembers)
+
+	// curl -X POST -H 'Content-Type: application/json' -d '{"username":"sdtc"}' localhost:8081/api/:chatID/members --cookie "token=<YOUR_TOKEN>"
+	protected.POST("/:chatID/members", chatController.AddMember)
+
+	// curl -X DELETE localhost:8081/api/:chatID/members/:username --cookie "token=<YOUR_TOKEN>"
+	protected.DELETE("/:chatID/members/:username", chatController.RemoveMember)
+
+	// curl -X POST localhost:8081/api/:chatID/leave --cookie "token=<YOUR_TOKEN>"
+	protected.POST("/:chatID/leave", chatController.LeaveChat)
+
 	sockets := e.Group("/ws")
 	sockets.Use(utils.CustomMiddleware)
-	// websocat "ws://localhost:8081/ws/join?id=<CHAT_ID>" -H "Cookie: token=<YOUR_TOKEN>"
+	// websocat "ws://localhost:8081/ws/join?device=<DEVICE_NAME>" -H "Cookie: token=<YOUR_TOKEN>"
 	sockets.GET("/join", chatController.JoinChat)
 
 	// Start server
-	e.Logger.Fatal(e.Start(":8081"))
+	go func() {
+		if err := e.Start(":8081"); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	// websockets are hijacked so the http server does not close them itself
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+
+	chatController.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		e.Logger.Fatal(err)
+	}
 }
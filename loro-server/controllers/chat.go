@@ -1,22 +1,32 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"server/core"
 	"server/db"
+	"server/models"
 	"server/services"
 
+	protocol "loro-protocol"
+
 	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 )
 
-func Upgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+func Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*websocket.Conn, error) {
 	upgrader := websocket.Upgrader{
 		ReadBufferSize:  512,
 		WriteBufferSize: 512,
+		// the codec is picked through Sec-WebSocket-Protocol, json without one
+		Subprotocols: protocol.Subprotocols,
 		CheckOrigin: func(r *http.Request) bool {
 			return r.Method == http.MethodGet
 		},
@@ -30,9 +40,11 @@ func Upgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
 	//	return nil, fmt.Errorf("invalid JWT")
 	//}
 
-	return upgrader.Upgrade(w, r, nil)
+	return upgrader.Upgrade(w, r, responseHeader)
 }
 
+const SessionHeader = "X-Loro-Session"
+
 type ChatController struct {
 	svc services.ChatService
 }
@@ -45,19 +57,77 @@ func NewChatController(repo *db.PostgresPool) ChatController {
 }
 
 func (ctrl ChatController) GetMembers(c echo.Context) error {
-	chatID, err := strconv.Atoi(c.QueryParam("chatID"))
+	chatID, err := strconv.Atoi(c.Param("chatID"))
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, err)
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
 	}
 
-	members, err := ctrl.svc.GetMembers(chatID)
+	members, err := ctrl.svc.GetMembers(currentUser(c), chatID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+		return c.JSON(errorStatus(err), err.Error())
 	}
 
 	return c.JSON(http.StatusOK, members)
 }
 
+func (ctrl ChatController) CreateGroup(c echo.Context) error {
+	group := new(models.GroupRequest)
+	if err := c.Bind(group); err != nil {
+		return err
+	}
+
+	chat, err := ctrl.svc.CreateGroup(currentUser(c), *group)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, chat)
+}
+
+func (ctrl ChatController) AddMember(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+
+	member := new(models.MemberRequest)
+	if err := c.Bind(member); err != nil {
+		return err
+	}
+
+	if err := ctrl.svc.AddMember(currentUser(c), chatID, member.Username); err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (ctrl ChatController) RemoveMember(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+
+	if err := ctrl.svc.RemoveMember(currentUser(c), chatID, c.Param("username")); err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (ctrl ChatController) LeaveChat(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+
+	if err := ctrl.svc.LeaveChat(currentUser(c), chatID); err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
 func (ctrl ChatController) GetMessages(c echo.Context) error {
 	chatID := c.Param("chatID")
 	if chatID == "" {
@@ -79,51 +149,566 @@ func (ctrl ChatController) GetMessages(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, fmt.Errorf("limit is not a number"))
 	}
 
-	offset := c.QueryParam("offset")
-	if offset == "" {
-		return c.JSON(http.StatusBadRequest, fmt.Errorf("offset required"))
+	if limitInt <= 0 {
+		return c.JSON(http.StatusBadRequest, fmt.Errorf("limit must be positive"))
 	}
 
-	offsetInt, err := strconv.Atoi(offset)
+	query := models.MessagesQuery{Limit: limitInt, Cursor: c.QueryParam("cursor")}
+	if before := c.QueryParam("before"); before != "" {
+		seq, err := strconv.ParseInt(before, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, fmt.Errorf("before is not a sequence"))
+		}
+		query.Before = &seq
+	}
+	if after := c.QueryParam("after"); after != "" {
+		seq, err := strconv.ParseInt(after, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, fmt.Errorf("after is not a sequence"))
+		}
+		query.After = &seq
+	}
+
+	page, err := ctrl.svc.GetMessages(currentUser(c), chatIDInt, query)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, fmt.Errorf("offset is not a number"))
+		return c.JSON(errorStatus(err), err.Error())
 	}
 
-	messages, err := ctrl.svc.GetMessages(chatIDInt, limitInt, offsetInt)
+	return c.JSON(http.StatusOK, page)
+}
+
+func (ctrl ChatController) GetMentions(c echo.Context) error {
+	mentions, err := ctrl.svc.GetMentions(currentUser(c))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+		return c.JSON(errorStatus(err), err.Error())
 	}
 
-	return c.JSON(http.StatusOK, messages)
+	return c.JSON(http.StatusOK, mentions)
 }
 
-func (ctrl ChatController) GetChats(c echo.Context) error {
-	//context has a map where "user" is the default key for jwt
-	token := c.Get("user").(*jwt.Token)
-	username := token.Claims.(jwt.MapClaims)["username"].(string)
+func (ctrl ChatController) PinMessage(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
 
-	chats, err := ctrl.svc.GetChats(username)
+	request := new(models.PinRequest)
+	if err := c.Bind(request); err != nil {
+		return err
+	}
+
+	pin, err := ctrl.svc.PinMessage(currentUser(c), chatID, request.MessageID, true)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, pin)
+}
+
+func (ctrl ChatController) UnpinMessage(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+	messageID, err := strconv.Atoi(c.Param("messageID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "message id is not a number")
+	}
+
+	if _, err := ctrl.svc.PinMessage(currentUser(c), chatID, messageID, false); err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (ctrl ChatController) GetPins(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+
+	pins, err := ctrl.svc.GetPins(currentUser(c), chatID)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, pins)
+}
+
+func (ctrl ChatController) Upload(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+
+	// room for the multipart headers around the file
+	maxBody := ctrl.svc.MaxUpload() + 64<<10
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxBody)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return c.JSON(http.StatusRequestEntityTooLarge, services.ErrFileTooLarge.Error())
+		}
+		return c.JSON(http.StatusBadRequest, "file required")
+	}
+	file, err := header.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	attachment, err := ctrl.svc.Upload(currentUser(c), chatID, header.Filename, file)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, attachment)
+}
+
+func (ctrl ChatController) Download(c echo.Context) error {
+	attachmentID, err := strconv.Atoi(c.Param("attachmentID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "attachment id is not a number")
+	}
+
+	attachment, content, err := ctrl.svc.Download(currentUser(c), attachmentID)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+	defer content.Close()
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment",
+		map[string]string{"filename": attachment.Filename}))
+	c.Response().Header().Set(echo.HeaderContentLength, strconv.FormatInt(attachment.Size, 10))
+	return c.Stream(http.StatusOK, attachment.ContentType, content)
+}
+
+func (ctrl ChatController) Search(c echo.Context) error {
+	query := models.SearchQuery{Q: c.QueryParam("q"), Cursor: c.QueryParam("cursor")}
+
+	if chat := c.QueryParam("chat"); chat != "" {
+		chatID, err := strconv.Atoi(chat)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "chat is not a number")
+		}
+		query.ChatID = &chatID
+	}
+	if sender := c.QueryParam("sender"); sender != "" {
+		query.Sender = &sender
+	}
+	if from := c.QueryParam("from"); from != "" {
+		parsed, err := parseDate(from)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "from is not a date")
+		}
+		query.From = &parsed
+	}
+	if to := c.QueryParam("to"); to != "" {
+		parsed, err := parseDate(to)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "to is not a date")
+		}
+		query.To = &parsed
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "limit is not a number")
+		}
+		query.Limit = limitInt
+	}
+
+	page, err := ctrl.svc.Search(currentUser(c), query)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// parseDate takes a moment in RFC 3339 or a day as 2006-01-02
+func parseDate(value string) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+func (ctrl ChatController) GetThread(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+	messageID, err := strconv.Atoi(c.Param("messageID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "message id is not a number")
+	}
+
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "limit is not a number")
+	}
+	if limit <= 0 {
+		return c.JSON(http.StatusBadRequest, "limit must be positive")
+	}
+	offset, err := strconv.Atoi(c.QueryParam("offset"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "offset is not a number")
+	}
+	if offset < 0 {
+		return c.JSON(http.StatusBadRequest, "offset must not be negative")
+	}
+
+	replies, err := ctrl.svc.GetThread(currentUser(c), chatID, messageID, limit, offset)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, replies)
+}
+
+func (ctrl ChatController) MarkRead(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+
+	read := new(models.ReadRequest)
+	if err := c.Bind(read); err != nil {
+		return err
+	}
+
+	if err := ctrl.svc.MarkRead(currentUser(c), chatID, read.MessageID); err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (ctrl ChatController) EditMessage(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+	messageID, err := strconv.Atoi(c.Param("messageID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "message id is not a number")
+	}
+
+	edit := new(models.EditRequest)
+	if err := c.Bind(edit); err != nil {
+		return err
+	}
+
+	edited, err := ctrl.svc.EditMessage(currentUser(c), chatID, messageID, edit.Body)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, edited)
+}
+
+func (ctrl ChatController) ToggleReaction(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+	messageID, err := strconv.Atoi(c.Param("messageID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "message id is not a number")
+	}
+
+	request := new(models.ReactionRequest)
+	if err := c.Bind(request); err != nil {
+		return err
+	}
+
+	reaction, err := ctrl.svc.ToggleReaction(currentUser(c), chatID, messageID, request.Emoji)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, reaction)
+}
+
+func (ctrl ChatController) DeleteMessage(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+	messageID, err := strconv.Atoi(c.Param("messageID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "message id is not a number")
+	}
+
+	// only for the user unless asked otherwise
+	forEveryone := c.QueryParam("for") == "everyone"
+	retract, err := ctrl.svc.DeleteMessage(currentUser(c), chatID, messageID, forEveryone)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, retract)
+}
+
+func (ctrl ChatController) GetRevisions(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+	messageID, err := strconv.Atoi(c.Param("messageID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "message id is not a number")
+	}
+
+	revisions, err := ctrl.svc.GetRevisions(currentUser(c), chatID, messageID)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, revisions)
+}
+
+func (ctrl ChatController) GetReceipts(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+
+	receipts, err := ctrl.svc.GetReceipts(currentUser(c), chatID)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, receipts)
+}
+
+func (ctrl ChatController) GetChats(c echo.Context) error {
+	chats, err := ctrl.svc.GetChats(currentUser(c), c.QueryParam("filter"))
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
 	}
 
 	return c.JSON(http.StatusOK, chats)
 }
 
+func (ctrl ChatController) SetChatState(c echo.Context) error {
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "chat id is not a number")
+	}
+
+	update := new(models.ChatStateUpdate)
+	if err := c.Bind(update); err != nil {
+		return err
+	}
+
+	updated, err := ctrl.svc.SetChatState(currentUser(c), chatID, *update)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, updated)
+}
+
+// most usernames a presence request may ask about
+const maxPresenceUsers = 100
+
+func (ctrl ChatController) GetPresence(c echo.Context) error {
+	usernames := make([]string, 0)
+	for _, username := range strings.Split(c.QueryParam("usernames"), ",") {
+		if username = strings.TrimSpace(username); username != "" {
+			usernames = append(usernames, username)
+		}
+	}
+	if len(usernames) > maxPresenceUsers {
+		return c.JSON(http.StatusBadRequest, fmt.Sprintf("at most %d usernames", maxPresenceUsers))
+	}
+
+	presence, err := ctrl.svc.GetPresence(currentUser(c), usernames)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, presence)
+}
+
+func (ctrl ChatController) GetBlocks(c echo.Context) error {
+	blocks, err := ctrl.svc.GetBlocks(currentUser(c))
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, blocks)
+}
+
+func (ctrl ChatController) Block(c echo.Context) error {
+	request := new(models.MemberRequest)
+	if err := c.Bind(request); err != nil {
+		return err
+	}
+
+	block, err := ctrl.svc.Block(currentUser(c), request.Username, true)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, block)
+}
+
+func (ctrl ChatController) Unblock(c echo.Context) error {
+	if _, err := ctrl.svc.Block(currentUser(c), c.Param("username"), false); err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (ctrl ChatController) GetContacts(c echo.Context) error {
+	contacts, err := ctrl.svc.GetContacts(currentUser(c))
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, contacts)
+}
+
+func (ctrl ChatController) GetContactRequests(c echo.Context) error {
+	requests, err := ctrl.svc.GetContactRequests(currentUser(c))
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, requests)
+}
+
+func (ctrl ChatController) RequestContact(c echo.Context) error {
+	request := new(models.MemberRequest)
+	if err := c.Bind(request); err != nil {
+		return err
+	}
+
+	contact, err := ctrl.svc.RequestContact(currentUser(c), request.Username)
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, contact)
+}
+
+func (ctrl ChatController) AcceptContact(c echo.Context) error {
+	contact, err := ctrl.svc.AcceptContact(currentUser(c), c.Param("username"))
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, contact)
+}
+
+func (ctrl ChatController) DeclineContact(c echo.Context) error {
+	if _, err := ctrl.svc.DeclineContact(currentUser(c), c.Param("username")); err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (ctrl ChatController) SetNickname(c echo.Context) error {
+	request := new(models.NicknameRequest)
+	if err := c.Bind(request); err != nil {
+		return err
+	}
+
+	if err := ctrl.svc.SetNickname(currentUser(c), c.Param("username"), request.Nickname); err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (ctrl ChatController) RemoveContact(c echo.Context) error {
+	if _, err := ctrl.svc.RemoveContact(currentUser(c), c.Param("username")); err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (ctrl ChatController) GetSessions(c echo.Context) error {
+	return c.JSON(http.StatusOK, ctrl.svc.GetSessions(currentUser(c)))
+}
+
+// Shutdown closes every websocket with a going away code
+func (ctrl ChatController) Shutdown() {
+	ctrl.svc.Shutdown()
+}
+
+func (ctrl ChatController) GetStats(c echo.Context) error {
+	stats, err := ctrl.svc.GetStats(currentUser(c))
+	if err != nil {
+		return c.JSON(errorStatus(err), err.Error())
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
 func (ctrl ChatController) JoinChat(c echo.Context) error {
-	ws, err := Upgrade(c.Response(), c.Request())
+	device := c.QueryParam("device")
+	if device == "" {
+		device = "unknown"
+	}
+	// the client learns its session id, protocol version and ping interval from the handshake response
+	sessionID := core.NewSessionID()
+	version := protocol.Negotiate(c.QueryParam(protocol.VersionParam))
+	header := http.Header{}
+	header.Set(SessionHeader, sessionID)
+	header.Set(protocol.VersionHeader, strconv.Itoa(version))
+	header.Set(protocol.PingIntervalHeader, strconv.Itoa(max(1, int(ctrl.svc.PingInterval()/time.Second))))
+
+	ws, err := Upgrade(c.Response(), c.Request(), header)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err)
 	}
 	defer ws.Close()
 
-	token := c.Get("user").(*jwt.Token)
-	username := token.Claims.(jwt.MapClaims)["username"].(string)
-
-	err = ctrl.svc.Subscribe(username, ws)
+	resume := c.QueryParam("resume") == "1"
+	err = ctrl.svc.Subscribe(currentUser(c), sessionID, device, version, resume, ws)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err)
 	}
 
 	return nil
 }
+
+func currentUser(c echo.Context) string {
+	//context has a map where "user" is the default key for jwt
+	token := c.Get("user").(*jwt.Token)
+	return token.Claims.(jwt.MapClaims)["username"].(string)
+}
+
+// errorStatus maps service errors to the http status sent to the client
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, core.ErrNotMember), errors.Is(err, services.ErrNotOwner), errors.Is(err, core.ErrNotSender),
+		errors.Is(err, core.ErrRetractWindow), errors.Is(err, core.ErrBlocked), errors.Is(err, core.ErrNotContact),
+		errors.Is(err, services.ErrNotAdmin):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrUserNotFound), errors.Is(err, core.ErrMessageNotFound),
+		errors.Is(err, core.ErrAttachmentNotFound), errors.Is(err, core.ErrRequestNotFound),
+		errors.Is(err, core.ErrContactNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrNotGroup), errors.Is(err, services.ErrInvalidGroup), errors.Is(err, core.ErrEmptyMessage),
+		errors.Is(err, core.ErrInvalidReaction), errors.Is(err, core.ErrTooManyPins),
+		errors.Is(err, services.ErrEmptyQuery), errors.Is(err, services.ErrInvalidCursor), errors.Is(err, services.ErrInvalidFilter),
+		errors.Is(err, core.ErrBlockSelf), errors.Is(err, core.ErrContactSelf), errors.Is(err, services.ErrInvalidNickname):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, services.ErrFileType):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusInternalServerError
+	}
+}
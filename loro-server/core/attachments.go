@@ -0,0 +1,31 @@
+package core
+
+import (
+	"context"
+	"errors"
+
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// AttachedFile is a column with the attachment of the message m as a json object, null without one
+const AttachedFile = `(select json_build_object('id', a.id, 'filename', a.filename,
+	'contentType', a.content_type, 'size', a.size) from attachments a where a.message_id = m.id)`
+
+/*
+attach links a file the user uploaded to the chat to the message and fills in
+what the members need to show it. A file goes with one message only.
+*/
+func attach(ctx context.Context, tx pgx.Tx, chatID, messageID int, uploaderID uint, attachment *models.Attachment) error {
+	err := tx.QueryRow(ctx, `update attachments set message_id = $1
+		where id = $2 and chat_id = $3 and uploader_id = $4 and message_id is null
+		returning filename, content_type, size`, messageID, attachment.ID, chatID, uploaderID).
+		Scan(&attachment.Filename, &attachment.ContentType, &attachment.Size)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrAttachmentNotFound
+	}
+	return err
+}
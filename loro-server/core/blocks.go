@@ -0,0 +1,149 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"server/db"
+	"server/db/utils"
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrBlocked   = errors.New("user is blocked")
+	ErrBlockSelf = errors.New("users cannot block themselves")
+)
+
+// blockedBetween is a condition true when the users a and b blocked one another, either way
+func blockedBetween(a, b string) string {
+	return `exists(select 1 from user_blocks ub where ub.blocker_id = ` + a + ` and ub.blocked_id = ` + b + `
+		or ub.blocker_id = ` + b + ` and ub.blocked_id = ` + a + `)`
+}
+
+// directBlocked reports whether the chat is a direct chat whose members blocked one another
+func directBlocked(ctx context.Context, q querier, chatID int, userID uint) (bool, error) {
+	var blocked bool
+	err := q.QueryRow(ctx, `select exists(select 1 from chats c
+		inner join chat_members cm on cm.chat_id = c.id and cm.user_id <> $2
+		where c.id = $1 and c.type = $3 and `+blockedBetween("$2", "cm.user_id")+`)`,
+		chatID, userID, ChatTypeDirect).Scan(&blocked)
+	return blocked, err
+}
+
+/*
+BlockUser blocks the username for the user or unblocks them. The sessions of
+the user are told so the socket manager stops or resumes delivering what the
+username sends, and both see the other offline while blocked. Blocking twice
+or unblocking a user who is not blocked changes nothing and sends nothing.
+*/
+func BlockUser(pool *db.PostgresPool, sm *SocketManager, user *utils.User, username string, blocked bool) (*models.Block, error) {
+	if username == *user.Username {
+		return nil, ErrBlockSelf
+	}
+	var blockedID uint
+	err := pool.QueryRow(context.Background(), `select id from users where username = $1`, username).Scan(&blockedID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tag pgconn.CommandTag
+	if blocked {
+		tag, err = pool.Execute(context.Background(), `insert into user_blocks(blocker_id, blocked_id) values($1, $2)
+			on conflict (blocker_id, blocked_id) do nothing`, *user.ID, blockedID)
+	} else {
+		tag, err = pool.Execute(context.Background(), `delete from user_blocks where blocker_id = $1 and blocked_id = $2`,
+			*user.ID, blockedID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block := &models.Block{Username: username, Blocked: blocked}
+	if tag.RowsAffected() == 0 {
+		return block, nil
+	}
+
+	sm.Messages <- &Delivery{
+		Recipients: []string{*user.Username},
+		Frame:      &models.Envelope{Type: models.TypeBlock, Block: block},
+	}
+	sendPresence(pool, sm, *user.ID, *user.Username, username, blocked)
+	sendPresence(pool, sm, blockedID, username, *user.Username, blocked)
+	return block, nil
+}
+
+// sendPresence tells the user whether the username is online, offline when they are blocked
+func sendPresence(pool *db.PostgresPool, sm *SocketManager, userID uint, user, username string, blocked bool) {
+	presence := []models.Presence{{Username: username}}
+	if !blocked {
+		var err error
+		presence, err = Presence(pool, sm, userID, []string{username})
+		if err != nil {
+			log.Printf("Presence of %s not sent to %s: %v", username, user, err)
+			return
+		}
+	}
+	for i := range presence {
+		sm.Messages <- &Delivery{
+			Recipients: []string{user},
+			Frame:      &models.Envelope{Type: models.TypePresence, Presence: &presence[i]},
+		}
+	}
+}
+
+/*
+LoadBlocks fetches who the user of the connection blocked, so the socket
+manager leaves out what they send. Later changes reach the connection as
+block frames.
+*/
+func (u *Connection) LoadBlocks() error {
+	rows, err := u.Pool.Query(context.Background(), `select u.username from user_blocks ub
+		inner join users u on u.id = ub.blocked_id where ub.blocker_id = $1`, *u.User.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	blocked := make(map[string]bool)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return err
+		}
+		blocked[username] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	u.blocked = blocked
+	u.mu.Unlock()
+	return nil
+}
+
+// blocks reports whether the user of the connection blocked the username
+func (u *Connection) blocks(username string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.blocked[username]
+}
+
+func (u *Connection) setBlocked(block *models.Block) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.blocked == nil {
+		u.blocked = make(map[string]bool)
+	}
+	if block.Blocked {
+		u.blocked[block.Username] = true
+	} else {
+		delete(u.blocked, block.Username)
+	}
+}
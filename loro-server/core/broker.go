@@ -0,0 +1,253 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"server/db"
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+/*
+Broker fans deliveries out to the other server instances. The socket manager
+always delivers to its own connections first and then publishes, so a broker
+only has to carry deliveries between instances and must not hand an instance
+its own deliveries back. It also shares which sessions each instance holds,
+so a user is online as long as any instance serves them.
+*/
+type Broker interface {
+	Publish(delivery *Delivery) error
+	// Subscribe calls handler with every delivery published by other instances
+	Subscribe(handler func(*Delivery)) error
+	// SessionJoined and SessionLeft tell the other instances about a session of this one
+	SessionJoined(username string, session models.Session) error
+	SessionLeft(sessionID string) error
+	// RemoteSessions returns the sessions other instances hold for the usernames
+	RemoteSessions(usernames []string) (map[string][]models.Session, error)
+	Close() error
+}
+
+// LocalBroker is used when a single instance serves every user
+type LocalBroker struct{}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{}
+}
+
+func (b *LocalBroker) Publish(delivery *Delivery) error { return nil }
+
+func (b *LocalBroker) Subscribe(handler func(*Delivery)) error { return nil }
+
+func (b *LocalBroker) SessionJoined(username string, session models.Session) error { return nil }
+
+func (b *LocalBroker) SessionLeft(sessionID string) error { return nil }
+
+func (b *LocalBroker) RemoteSessions(usernames []string) (map[string][]models.Session, error) {
+	return map[string][]models.Session{}, nil
+}
+
+func (b *LocalBroker) Close() error { return nil }
+
+const (
+	hubChannel = "loro_hub"
+	// postgres rejects notification payloads from 8000 bytes on
+	maxNotifyPayload = 7999
+	relistenDelay    = 2 * time.Second
+	// stored deliveries are read right away, older ones are of no use to anyone
+	storedDeliveryAge = time.Minute
+	// how often an instance tells it still holds its sessions
+	sessionHeartbeat = 30 * time.Second
+	// sessions of an instance silent for this long are taken for gone
+	sessionExpiry = 3 * sessionHeartbeat
+)
+
+// a notification carries the delivery itself or, when too large, the id it was stored under
+type notification struct {
+	Origin   string    `json:"origin"`
+	Delivery *Delivery `json:"delivery,omitempty"`
+	Stored   *int64    `json:"stored,omitempty"`
+}
+
+// hubStore is what the postgres broker needs from the database
+type hubStore interface {
+	Notify(ctx context.Context, channel, payload string) error
+	Listen(ctx context.Context, channel string, handler func(payload string)) error
+	QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row
+	Execute(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+/*
+PostgresBroker shares deliveries between instances with LISTEN/NOTIFY on the
+database every instance already uses. Deliveries too large for a notification
+are stored in hub_deliveries and only their id is notified. Sessions are kept
+in hub_sessions, each instance refreshing its own while it runs.
+*/
+type PostgresBroker struct {
+	store    hubStore
+	instance string
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func NewPostgresBroker(pool *db.PostgresPool) *PostgresBroker {
+	return newPostgresBroker(pool)
+}
+
+func newPostgresBroker(store hubStore) *PostgresBroker {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &PostgresBroker{
+		store:    store,
+		instance: randomID(),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	go b.heartbeat()
+	return b
+}
+
+// heartbeat keeps the sessions of this instance from expiring until the broker closes
+func (b *PostgresBroker) heartbeat() {
+	ticker := time.NewTicker(sessionHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_, err := b.store.Execute(b.ctx, `update hub_sessions set seen_at = now() where instance = $1`, b.instance)
+			if err != nil && b.ctx.Err() == nil {
+				log.Println("Error refreshing hub sessions:", err.Error())
+			}
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *PostgresBroker) SessionJoined(username string, session models.Session) error {
+	_, err := b.store.Execute(b.ctx, `insert into hub_sessions(session_id, instance, username, device, connected_at)
+		values($1, $2, $3, $4, $5) on conflict (session_id) do update set seen_at = now()`,
+		session.ID, b.instance, username, session.Device, session.ConnectedAt)
+	return err
+}
+
+func (b *PostgresBroker) SessionLeft(sessionID string) error {
+	_, err := b.store.Execute(b.ctx, `delete from hub_sessions where session_id = $1`, sessionID)
+	return err
+}
+
+func (b *PostgresBroker) RemoteSessions(usernames []string) (map[string][]models.Session, error) {
+	var found []byte
+	err := b.store.QueryRow(b.ctx, `select coalesce(json_agg(json_build_object('username', username,
+		'id', session_id, 'device', device, 'connected_at', connected_at)), '[]') from hub_sessions
+		where username = any($1) and instance <> $2 and seen_at > $3`,
+		usernames, b.instance, time.Now().Add(-sessionExpiry)).Scan(&found)
+	if err != nil {
+		return nil, err
+	}
+
+	remote := make([]struct {
+		Username string `json:"username"`
+		models.Session
+	}, 0)
+	if err := json.Unmarshal(found, &remote); err != nil {
+		return nil, err
+	}
+	sessions := make(map[string][]models.Session)
+	for _, session := range remote {
+		sessions[session.Username] = append(sessions[session.Username], session.Session)
+	}
+	return sessions, nil
+}
+
+func (b *PostgresBroker) Publish(delivery *Delivery) error {
+	payload, err := json.Marshal(notification{Origin: b.instance, Delivery: delivery})
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > maxNotifyPayload {
+		body, err := json.Marshal(delivery)
+		if err != nil {
+			return err
+		}
+		var id int64
+		err = b.store.QueryRow(b.ctx, `with expired as (delete from hub_deliveries where created_at < $2)
+			insert into hub_deliveries(payload) values($1) returning id`,
+			string(body), time.Now().Add(-storedDeliveryAge)).Scan(&id)
+		if err != nil {
+			return err
+		}
+		payload, err = json.Marshal(notification{Origin: b.instance, Stored: &id})
+		if err != nil {
+			return err
+		}
+	}
+
+	return b.store.Notify(b.ctx, hubChannel, string(payload))
+}
+
+// stored reads a delivery published through hub_deliveries
+func (b *PostgresBroker) stored(id int64) (*Delivery, error) {
+	var body string
+	err := b.store.QueryRow(b.ctx, `select payload from hub_deliveries where id = $1`, id).Scan(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := &Delivery{}
+	if err := json.Unmarshal([]byte(body), delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (b *PostgresBroker) Subscribe(handler func(*Delivery)) error {
+	go func() {
+		for b.ctx.Err() == nil {
+			err := b.store.Listen(b.ctx, hubChannel, func(payload string) {
+				n := notification{}
+				if err := json.Unmarshal([]byte(payload), &n); err != nil {
+					log.Println("Error on broker notification:", err.Error())
+					return
+				}
+				// this instance already delivered its own messages
+				if n.Origin == b.instance {
+					return
+				}
+				if n.Stored != nil {
+					delivery, err := b.stored(*n.Stored)
+					if err != nil {
+						log.Printf("Error reading stored delivery %d: %v", *n.Stored, err)
+						return
+					}
+					n.Delivery = delivery
+				}
+				if n.Delivery == nil {
+					return
+				}
+				handler(n.Delivery)
+			})
+			if b.ctx.Err() != nil {
+				return
+			}
+			log.Println("Broker stopped listening, retrying:", err)
+			time.Sleep(relistenDelay)
+		}
+	}()
+
+	return nil
+}
+
+func (b *PostgresBroker) Close() error {
+	b.cancel()
+	// other instances should not wait for the sessions to expire
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := b.store.Execute(ctx, `delete from hub_sessions where instance = $1`, b.instance)
+	return err
+}
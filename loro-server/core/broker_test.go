@@ -0,0 +1,123 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStore stands in for postgres, rejecting notifications as large as it does
+type memoryStore struct {
+	mu        sync.Mutex
+	listeners []chan string
+	rows      map[int64]string
+}
+
+func (s *memoryStore) Notify(ctx context.Context, channel, payload string) error {
+	if len(payload) > maxNotifyPayload {
+		return errors.New("payload string too long")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, listener := range s.listeners {
+		listener <- payload
+	}
+	return nil
+}
+
+func (s *memoryStore) Listen(ctx context.Context, channel string, handler func(payload string)) error {
+	listener := make(chan string, 8)
+	s.mu.Lock()
+	s.listeners = append(s.listeners, listener)
+	s.mu.Unlock()
+
+	for {
+		select {
+		case payload := <-listener:
+			handler(payload)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *memoryStore) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if strings.Contains(query, "insert into hub_deliveries") {
+		id := int64(len(s.rows) + 1)
+		s.rows[id] = args[0].(string)
+		return memoryRow{id}
+	}
+	payload, ok := s.rows[args[0].(int64)]
+	if !ok {
+		return memoryRow{pgx.ErrNoRows}
+	}
+	return memoryRow{payload}
+}
+
+func (s *memoryStore) Execute(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+type memoryRow struct {
+	value interface{}
+}
+
+func (r memoryRow) Scan(dest ...interface{}) error {
+	switch value := r.value.(type) {
+	case error:
+		return value
+	case int64:
+		*dest[0].(*int64) = value
+	case string:
+		*dest[0].(*string) = value
+	}
+	return nil
+}
+
+func TestPostgresBrokerCarriesLargeDeliveries(t *testing.T) {
+	store := &memoryStore{rows: make(map[int64]string)}
+	sender, receiver := newPostgresBroker(store), newPostgresBroker(store)
+	defer sender.Close()
+	defer receiver.Close()
+
+	received := make(chan *Delivery, 2)
+	require.NoError(t, receiver.Subscribe(func(delivery *Delivery) {
+		received <- delivery
+	}))
+	require.Eventually(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.listeners) == 1
+	}, time.Second, time.Millisecond)
+
+	for _, size := range []int{10, 3 * maxNotifyPayload} {
+		body := strings.Repeat("a", size)
+		delivery := &Delivery{
+			Recipients: []string{"ana"},
+			Sender:     "bob",
+			Frame:      &models.Envelope{Type: models.TypeMessage, Message: &models.Message{Body: &body}},
+		}
+		require.NoError(t, sender.Publish(delivery))
+
+		select {
+		case got := <-received:
+			require.Equal(t, delivery, got)
+		case <-time.After(time.Second):
+			t.Fatalf("delivery of %d bytes never arrived", size)
+		}
+	}
+	// only the large delivery went through the table
+	require.Len(t, store.rows, 1)
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	ChatTypeDirect = "direct"
+	ChatTypeGroup  = "group"
+
+	RoleOwner  = "owner"
+	RoleMember = "member"
+)
+
+var (
+	ErrNotMember    = errors.New("user is not a member of the chat")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// querier is satisfied by db.PostgresPool and pgx.Tx
+type querier interface {
+	Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row
+}
+
+// directReceiver selects the member of a direct chat other than the sender, null in a group
+func directReceiver(chatID, senderID string) string {
+	return `(select ru.username from chats rc
+		inner join chat_members rm on rm.chat_id = rc.id and rm.user_id <> ` + senderID + `
+		inner join users ru on ru.id = rm.user_id
+		where rc.id = ` + chatID + ` and rc.type = '` + ChatTypeDirect + `')`
+}
+
+// ChatMembers returns the usernames of every member of the chat.
+func ChatMembers(ctx context.Context, q querier, chatID int) ([]string, error) {
+	rows, err := q.Query(ctx, `select u.username from users u
+		inner join chat_members cm on u.id = cm.user_id where cm.chat_id = $1`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]string, 0)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		members = append(members, username)
+	}
+
+	return members, rows.Err()
+}
+
+// IsMember reports whether the user belongs to the chat.
+func IsMember(ctx context.Context, q querier, chatID int, userID uint) (bool, error) {
+	var ok bool
+	err := q.QueryRow(ctx, `select exists(select 1 from chat_members where chat_id = $1 and user_id = $2)`,
+		chatID, userID).Scan(&ok)
+	return ok, err
+}
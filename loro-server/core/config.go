@@ -0,0 +1,108 @@
+package core
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// OverflowDrop discards frames for a client whose queue is full
+	OverflowDrop = "drop"
+	// OverflowDisconnect closes the connection of a client whose queue is full
+	OverflowDisconnect = "disconnect"
+)
+
+/*
+Config tunes how the socket manager talks to clients. Every value can be set
+from the environment, see LoadConfig.
+*/
+type Config struct {
+	// frames waiting to be written per connection
+	SendQueue int
+	// time allowed to write a frame to a client
+	WriteTimeout time.Duration
+	// what to do when a client does not keep up, OverflowDrop or OverflowDisconnect
+	Overflow string
+	// how often clients are pinged
+	PingInterval time.Duration
+	// time without any frame or pong before a connection is considered dead
+	PongWait time.Duration
+	// how long after sending a message it can be deleted for everyone, 0 for ever
+	RetractWindow time.Duration
+	// whether direct messages are only allowed between contacts
+	ContactsOnly bool
+}
+
+func DefaultConfig() Config {
+	return Config{
+		SendQueue:     64,
+		WriteTimeout:  10 * time.Second,
+		Overflow:      OverflowDisconnect,
+		PingInterval:  30 * time.Second,
+		PongWait:      60 * time.Second,
+		RetractWindow: time.Hour,
+	}
+}
+
+/*
+LoadConfig reads WS_SEND_QUEUE, WS_WRITE_TIMEOUT, WS_OVERFLOW_POLICY,
+WS_PING_INTERVAL, WS_PONG_WAIT, MSG_RETRACT_WINDOW and DM_CONTACTS_ONLY.
+*/
+func LoadConfig() Config {
+	config := DefaultConfig()
+
+	if value := os.Getenv("WS_SEND_QUEUE"); value != "" {
+		if size, err := strconv.Atoi(value); err == nil && size > 0 {
+			config.SendQueue = size
+		} else {
+			log.Printf("Ignoring WS_SEND_QUEUE=%s", value)
+		}
+	}
+
+	config.WriteTimeout = envDuration("WS_WRITE_TIMEOUT", config.WriteTimeout)
+
+	switch value := os.Getenv("WS_OVERFLOW_POLICY"); value {
+	case "":
+	case OverflowDrop, OverflowDisconnect:
+		config.Overflow = value
+	default:
+		log.Printf("Ignoring WS_OVERFLOW_POLICY=%s", value)
+	}
+
+	config.PingInterval = envDuration("WS_PING_INTERVAL", config.PingInterval)
+	config.PongWait = envDuration("WS_PONG_WAIT", config.PongWait)
+	if config.PongWait <= config.PingInterval {
+		// a client must get at least one ping before its deadline
+		log.Printf("WS_PONG_WAIT must be longer than WS_PING_INTERVAL, using %s", 2*config.PingInterval)
+		config.PongWait = 2 * config.PingInterval
+	}
+
+	config.RetractWindow = envDuration("MSG_RETRACT_WINDOW", config.RetractWindow)
+
+	if value := os.Getenv("DM_CONTACTS_ONLY"); value != "" {
+		if only, err := strconv.ParseBool(value); err == nil {
+			config.ContactsOnly = only
+		} else {
+			log.Printf("Ignoring DM_CONTACTS_ONLY=%s", value)
+		}
+	}
+
+	return config
+}
+
+// envDuration parses values like 10s or 1m, fallback is used when unset or invalid
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Ignoring %s=%s", key, value)
+		return fallback
+	}
+	return duration
+}
@@ -0,0 +1,77 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"server/core"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		want func(config *core.Config)
+	}{
+		{
+			name: "defaults",
+			want: func(config *core.Config) {},
+		},
+		{
+			name: "every value set",
+			env: map[string]string{
+				"WS_SEND_QUEUE":      "8",
+				"WS_WRITE_TIMEOUT":   "2s",
+				"WS_OVERFLOW_POLICY": core.OverflowDrop,
+				"WS_PING_INTERVAL":   "5s",
+				"WS_PONG_WAIT":       "15s",
+				"MSG_RETRACT_WINDOW": "10m",
+				"DM_CONTACTS_ONLY":   "true",
+			},
+			want: func(config *core.Config) {
+				config.SendQueue = 8
+				config.WriteTimeout = 2 * time.Second
+				config.Overflow = core.OverflowDrop
+				config.PingInterval = 5 * time.Second
+				config.PongWait = 15 * time.Second
+				config.RetractWindow = 10 * time.Minute
+				config.ContactsOnly = true
+			},
+		},
+		{
+			name: "invalid values are ignored",
+			env: map[string]string{
+				"WS_SEND_QUEUE":      "-1",
+				"WS_WRITE_TIMEOUT":   "soon",
+				"WS_OVERFLOW_POLICY": "block",
+				"MSG_RETRACT_WINDOW": "0",
+				"DM_CONTACTS_ONLY":   "maybe",
+			},
+			want: func(config *core.Config) {},
+		},
+		{
+			name: "pong wait outlasts the ping interval",
+			env: map[string]string{
+				"WS_PING_INTERVAL": "40s",
+				"WS_PONG_WAIT":     "30s",
+			},
+			want: func(config *core.Config) {
+				config.PingInterval = 40 * time.Second
+				config.PongWait = 80 * time.Second
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, key := range []string{"WS_SEND_QUEUE", "WS_WRITE_TIMEOUT", "WS_OVERFLOW_POLICY", "WS_PING_INTERVAL",
+				"WS_PONG_WAIT", "MSG_RETRACT_WINDOW", "DM_CONTACTS_ONLY"} {
+				t.Setenv(key, tc.env[key])
+			}
+
+			want := core.DefaultConfig()
+			tc.want(&want)
+			require.Equal(t, want, core.LoadConfig())
+		})
+	}
+}
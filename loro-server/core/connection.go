@@ -2,15 +2,21 @@ package core
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"server/db"
 	"server/db/utils"
 	"server/models"
 
+	protocol "loro-protocol"
+
 	"github.com/gorilla/websocket"
 	"github.com/jackc/pgx/v5"
 )
@@ -18,107 +24,686 @@ import (
 /*
 Connection has own web socket connection, database client. Connection needs a
 socket manager to send and receive message from other connections(users).
+Every connection is a session of its user, identified by SessionID and the
+device name the client reported when joining.
+
+Frames for the client are queued and written by the connection's own write
+pump, so a slow client never blocks the socket manager.
 */
 type Connection struct {
 	User          *utils.User
 	Conn          *websocket.Conn
 	SocketManager *SocketManager
 	Pool          *db.PostgresPool
+	SessionID     string
+	Device        string
+	ConnectedAt   time.Time
+	// protocol version and codec agreed with the client when it joined
+	Version int
+	Codec   protocol.Codec
+	// frames dropped because the client did not keep up
+	Dropped atomic.Uint64
+
+	send chan []byte
+	done chan struct{}
+
+	// frames from the socket manager wait in held while the client resumes
+	mu        sync.Mutex
+	holding   bool
+	resuming  bool
+	held      []*models.Envelope
+	holdTimer *time.Timer
+	// first sequence sent live per chat once the hold ran out before a resume
+	liveFrom map[int]int64
+	// users the user blocked, their frames are not delivered
+	blocked map[string]bool
+}
+
+func NewConnection(user *utils.User, ws *websocket.Conn, sm *SocketManager, pool *db.PostgresPool, sessionID, device string) *Connection {
+	return &Connection{
+		User:          user,
+		Conn:          ws,
+		SocketManager: sm,
+		Pool:          pool,
+		SessionID:     sessionID,
+		Device:        device,
+		ConnectedAt:   time.Now(),
+		Version:       protocol.Version,
+		Codec:         protocol.JSON,
+		send:          make(chan []byte, sm.config.SendQueue),
+		done:          make(chan struct{}),
+	}
+}
+
+// NewSessionID returns a random identifier for a websocket session
+func NewSessionID() string {
+	return randomID()
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (u *Connection) Session() models.Session {
+	return models.Session{
+		ID:          u.SessionID,
+		Device:      u.Device,
+		ConnectedAt: u.ConnectedAt,
+		Dropped:     u.Dropped.Load(),
+	}
 }
 
 /*
-Listen() is a for-loop where connections get incoming message by websocket.
-The message is serialized, stored in DB and then sent to websocket manager.
+Listen() is a for-loop where connections get incoming frames by websocket.
+Each frame is serialized and handled according to its type.
 */
 func (u *Connection) Listen() error {
+	go u.writePump()
 	defer func() {
+		close(u.done)
 		//notify connection dropped to socket manager
 		u.SocketManager.Leave <- u
 	}()
+
+	// a client that neither writes nor answers pings in time is dead
+	pongWait := u.SocketManager.config.PongWait
+	_ = u.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	u.Conn.SetPongHandler(func(string) error {
+		return u.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
 	for {
 		if _, message, err := u.Conn.ReadMessage(); err != nil {
-			log.Println("Error on read message =>\n", err.Error())
+			log.Printf("Error on read message [session %s] =>\n %s", u.SessionID, err.Error())
 			return err
 		} else {
-			msgSerialized := &models.Message{}
-			err := json.Unmarshal(message, msgSerialized)
+			_ = u.Conn.SetReadDeadline(time.Now().Add(pongWait))
+			frame, err := u.decode(message)
 			if err != nil {
 				log.Print(err)
+				u.reject(nil, nil, models.ErrCodeInvalid, "frame is not valid json")
+				continue
+			}
+			if frame == nil || !frame.Known() {
+				// newer clients may send frames this server does not know yet
+				log.Printf("Ignoring frame from session %s", u.SessionID)
+				continue
 			}
 
-			// if message creation fails then update chat throws panic
-			err = u.Pool.Transaction(context.Background(), func(tx pgx.Tx) error {
-				err := tx.QueryRow(context.Background(), `insert into messages(body, created_at, user_messages) values($1, $2, $3) returning id`,
-					msgSerialized.Body, time.Now(), u.User.ID).Scan(&msgSerialized.ID)
-				if err != nil {
-					return err
-				}
+			switch frame.Type {
+			case models.TypeMessage:
+				u.handleMessage(frame.Message)
+			case models.TypeRead:
+				u.handleRead(frame.Read)
+			case models.TypeTyping:
+				u.handleTyping(frame.Typing)
+			case models.TypeResume:
+				u.handleResume(frame.Resume)
+			case models.TypeEdit:
+				u.handleEdit(frame.Edit)
+			case models.TypeRetract:
+				u.handleRetract(frame.Retract)
+			case models.TypeReaction:
+				u.handleReaction(frame.Reaction)
+			case models.TypePin:
+				u.handlePin(frame.Pin)
+			default:
+				log.Printf("Unexpected %s frame from session %s", frame.Type, u.SessionID)
+			}
+		}
+	}
+}
 
-				if msgSerialized.ChatID == nil {
-					// check if recipient exists
-					var recipientID *uint
-					err := tx.QueryRow(context.Background(), `select id from users where username = $1`, msgSerialized.Receiver).Scan(&recipientID)
-					if err != nil {
-						return err
-					}
-
-					// check chat between users was already created
-					err = tx.QueryRow(context.Background(), `with user_chats as (
-																select cm.chat_id from chat_members cm where cm.user_id = $1
-															) select uc.chat_id from user_chats uc
-															inner join chat_members cm on cm.chat_id  = uc.chat_id
-															where cm.user_id = $2`, *recipientID, *u.User.ID).Scan(&msgSerialized.ChatID)
-					if err != nil {
-						if errors.Is(err, pgx.ErrNoRows) {
-							// create chat
-							err = tx.QueryRow(context.Background(), `insert into chats(type) values($1) returning id`, "public").Scan(&msgSerialized.ChatID)
-							if err != nil {
-								return err
-							}
-
-							_, err = tx.Exec(context.Background(), `insert into chat_members(chat_id, user_id) values($1, $2)`, *msgSerialized.ChatID, *u.User.ID)
-							if err != nil {
-								return err
-							}
-
-							_, err = tx.Exec(context.Background(), `insert into chat_members(chat_id, user_id) values($1, $2)`, *msgSerialized.ChatID, *recipientID)
-							if err != nil {
-								return err
-							}
-						} else {
-							return err
-						}
-					}
+/*
+handleMessage stores the message, acknowledges it to the sender and sends it
+to the socket manager. A message carrying a client id that was already stored
+is acknowledged again without being stored or sent twice.
+*/
+func (u *Connection) handleMessage(msg *models.Message) {
+	if msg.ChatID == nil && msg.Receiver == nil {
+		u.reject(msg.ClientID, msg.ChatID, models.ErrCodeInvalid, "message needs a chat or a receiver")
+		return
+	}
+	if (msg.Body == nil || *msg.Body == "") && msg.Attachment == nil {
+		u.reject(msg.ClientID, msg.ChatID, models.ErrCodeInvalid, "message is empty")
+		return
+	}
+	if msg.Body == nil {
+		// a file alone needs no caption
+		empty := ""
+		msg.Body = &empty
+	}
+	// the sender is always the owner of the connection
+	msg.Sender = u.User.Username
 
-				}
+	duplicate, members, err := u.storeMessage(msg)
+	switch {
+	case errors.Is(err, ErrNotMember):
+		u.reject(msg.ClientID, msg.ChatID, models.ErrCodeNotMember, err.Error())
+		return
+	case errors.Is(err, ErrBlocked), errors.Is(err, ErrNotContact):
+		u.reject(msg.ClientID, msg.ChatID, models.ErrCodeForbidden, err.Error())
+		return
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, ErrMessageNotFound), errors.Is(err, ErrAttachmentNotFound):
+		u.reject(msg.ClientID, msg.ChatID, models.ErrCodeNotFound, err.Error())
+		return
+	case err != nil:
+		log.Printf("Message from %s [session %s] not stored: %v", *u.User.Username, u.SessionID, err)
+		u.reject(msg.ClientID, msg.ChatID, models.ErrCodeInternal, "message could not be stored")
+		return
+	}
+
+	u.Send(&models.Envelope{Type: models.TypeAck, Ack: &models.Ack{
+		ID:        *msg.ID,
+		ClientID:  msg.ClientID,
+		ChatID:    *msg.ChatID,
+		Seq:       *msg.Seq,
+		CreatedAt: *msg.CreatedAt,
+	}})
+	if duplicate {
+		// members are not loaded for a retry, caching none would reject everyone's typing
+		return
+	}
+
+	u.SocketManager.members.put(*msg.ChatID, members)
+	u.SocketManager.Messages <- &Delivery{
+		Recipients: members,
+		Sender:     *u.User.Username,
+		Frame:      &models.Envelope{Type: models.TypeMessage, Message: msg},
+	}
+
+	sendMention(u.SocketManager, &models.Mention{
+		ChatID:    *msg.ChatID,
+		MessageID: *msg.ID,
+		Seq:       *msg.Seq,
+		Sender:    *msg.Sender,
+		Body:      *msg.Body,
+		CreatedAt: *msg.CreatedAt,
+	}, msg.Mentions)
+
+	sendUnread(u.Pool, u.SocketManager, *msg.ChatID, others(members, *u.User.Username))
+}
+
+func (u *Connection) handleRead(read *models.Read) {
+	err := MarkRead(u.Pool, u.SocketManager, u.User, read.ChatID, read.MessageID)
+	if errors.Is(err, ErrNotMember) {
+		u.reject(nil, &read.ChatID, models.ErrCodeNotMember, err.Error())
+	} else if err != nil {
+		log.Printf("Read cursor of %s not stored: %v", *u.User.Username, err)
+		u.reject(nil, &read.ChatID, models.ErrCodeInternal, "read cursor could not be stored")
+	}
+}
+
+func (u *Connection) handleEdit(edit *models.Edit) {
+	_, err := EditMessage(u.Pool, u.SocketManager, u.User, edit.ChatID, edit.MessageID, edit.Body)
+	switch {
+	case errors.Is(err, ErrEmptyMessage):
+		u.reject(nil, &edit.ChatID, models.ErrCodeInvalid, err.Error())
+	case errors.Is(err, ErrNotMember):
+		u.reject(nil, &edit.ChatID, models.ErrCodeNotMember, err.Error())
+	case errors.Is(err, ErrMessageNotFound):
+		u.reject(nil, &edit.ChatID, models.ErrCodeNotFound, err.Error())
+	case errors.Is(err, ErrNotSender):
+		u.reject(nil, &edit.ChatID, models.ErrCodeForbidden, err.Error())
+	case err != nil:
+		log.Printf("Edit of message %d by %s not stored: %v", edit.MessageID, *u.User.Username, err)
+		u.reject(nil, &edit.ChatID, models.ErrCodeInternal, "edit could not be stored")
+	}
+}
+
+func (u *Connection) handleReaction(reaction *models.Reaction) {
+	_, err := ToggleReaction(u.Pool, u.SocketManager, u.User, reaction.ChatID, reaction.MessageID, reaction.Emoji)
+	switch {
+	case errors.Is(err, ErrInvalidReaction):
+		u.reject(nil, &reaction.ChatID, models.ErrCodeInvalid, err.Error())
+	case errors.Is(err, ErrNotMember):
+		u.reject(nil, &reaction.ChatID, models.ErrCodeNotMember, err.Error())
+	case errors.Is(err, ErrMessageNotFound):
+		u.reject(nil, &reaction.ChatID, models.ErrCodeNotFound, err.Error())
+	case err != nil:
+		log.Printf("Reaction of %s on message %d not stored: %v", *u.User.Username, reaction.MessageID, err)
+		u.reject(nil, &reaction.ChatID, models.ErrCodeInternal, "reaction could not be stored")
+	}
+}
 
-				_, err = tx.Exec(context.Background(), `insert into chat_messages(chat_id, message_id) values($1, $2)`,
-					*msgSerialized.ChatID, *msgSerialized.ID)
+func (u *Connection) handlePin(pin *models.Pin) {
+	_, err := PinMessage(u.Pool, u.SocketManager, u.User, pin.ChatID, pin.MessageID, pin.Pinned)
+	switch {
+	case errors.Is(err, ErrTooManyPins):
+		u.reject(nil, &pin.ChatID, models.ErrCodeInvalid, err.Error())
+	case errors.Is(err, ErrNotMember):
+		u.reject(nil, &pin.ChatID, models.ErrCodeNotMember, err.Error())
+	case errors.Is(err, ErrMessageNotFound):
+		u.reject(nil, &pin.ChatID, models.ErrCodeNotFound, err.Error())
+	case err != nil:
+		log.Printf("Pin of message %d by %s not stored: %v", pin.MessageID, *u.User.Username, err)
+		u.reject(nil, &pin.ChatID, models.ErrCodeInternal, "pin could not be stored")
+	}
+}
+
+func (u *Connection) handleRetract(retract *models.Retract) {
+	var err error
+	if retract.ForEveryone {
+		_, err = RetractMessage(u.Pool, u.SocketManager, u.User, retract.ChatID, retract.MessageID)
+	} else {
+		_, err = HideMessage(u.Pool, u.SocketManager, u.User, retract.ChatID, retract.MessageID)
+	}
+	switch {
+	case errors.Is(err, ErrNotMember):
+		u.reject(nil, &retract.ChatID, models.ErrCodeNotMember, err.Error())
+	case errors.Is(err, ErrMessageNotFound):
+		u.reject(nil, &retract.ChatID, models.ErrCodeNotFound, err.Error())
+	case errors.Is(err, ErrNotSender), errors.Is(err, ErrRetractWindow):
+		u.reject(nil, &retract.ChatID, models.ErrCodeForbidden, err.Error())
+	case err != nil:
+		log.Printf("Delete of message %d by %s not stored: %v", retract.MessageID, *u.User.Username, err)
+		u.reject(nil, &retract.ChatID, models.ErrCodeInternal, "delete could not be stored")
+	}
+}
+
+/*
+handleTyping relays a typing frame to the other members of the chat. Members
+come from the socket manager's cache so typing rarely reaches the database,
+and nothing about it is stored.
+*/
+func (u *Connection) handleTyping(typing *models.Typing) {
+	if typing.State != models.TypingStart && typing.State != models.TypingStop {
+		u.reject(nil, &typing.ChatID, models.ErrCodeInvalid, "typing needs a start or stop state")
+		return
+	}
+
+	members, ok := u.SocketManager.members.get(typing.ChatID)
+	if !ok {
+		var err error
+		members, err = ChatMembers(context.Background(), u.Pool, typing.ChatID)
+		if err != nil {
+			log.Printf("Members of chat %d not loaded: %v", typing.ChatID, err)
+			return
+		}
+		u.SocketManager.members.put(typing.ChatID, members)
+	}
+
+	others := make([]string, 0, len(members))
+	member := false
+	for _, username := range members {
+		if username == *u.User.Username {
+			member = true
+		} else {
+			others = append(others, username)
+		}
+	}
+	if !member {
+		u.reject(nil, &typing.ChatID, models.ErrCodeNotMember, ErrNotMember.Error())
+		return
+	}
+
+	u.SocketManager.Messages <- &Delivery{
+		Recipients: others,
+		Sender:     *u.User.Username,
+		Frame: &models.Envelope{Type: models.TypeTyping, Typing: &models.Typing{
+			ChatID:   typing.ChatID,
+			Username: *u.User.Username,
+			State:    typing.State,
+		}},
+	}
+}
+
+// reject answers a frame with an error frame naming its client id and chat
+func (u *Connection) reject(clientID *string, chatID *int, code, message string) {
+	u.Send(&models.Envelope{Type: models.TypeError, Error: &models.Error{
+		ClientID: clientID,
+		ChatID:   chatID,
+		Code:     code,
+		Message:  message,
+	}})
+}
+
+/*
+storeMessage saves the message in its chat and fills its id, chat and
+creation time. It returns the members of the chat, or duplicate when the
+client id was already stored.
+*/
+func (u *Connection) storeMessage(msg *models.Message) (duplicate bool, members []string, err error) {
+	// if message creation fails then update chat throws panic
+	err = u.Pool.Transaction(context.Background(), func(tx pgx.Tx) error {
+		if msg.ClientID != nil {
+			found, err := storedMessage(tx, *u.User.ID, *msg.ClientID, msg)
+			if err != nil || found {
+				duplicate = found
+				return err
+			}
+		}
+
+		if msg.ChatID == nil {
+			chatID, err := directChat(tx, *u.User.ID, *msg.Receiver, u.SocketManager.config.ContactsOnly)
+			if err != nil {
+				return err
+			}
+			msg.ChatID = chatID
+		} else {
+			ok, err := IsMember(context.Background(), tx, *msg.ChatID, *u.User.ID)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrNotMember
+			}
+			blocked, err := directBlocked(context.Background(), tx, *msg.ChatID, *u.User.ID)
+			if err != nil {
+				return err
+			}
+			if blocked {
+				return ErrBlocked
+			}
+			if u.SocketManager.config.ContactsOnly {
+				stranger, err := directStranger(context.Background(), tx, *msg.ChatID, *u.User.ID)
 				if err != nil {
 					return err
 				}
+				if stranger {
+					return ErrNotContact
+				}
+			}
+		}
+
+		// a reply answers a message of the same chat
+		if msg.ReplyTo != nil {
+			quote, err := quoteParent(context.Background(), tx, *msg.ChatID, *msg.ReplyTo)
+			if err != nil {
+				return err
+			}
+			msg.Quote = quote
+		}
+
+		err = tx.QueryRow(context.Background(), `insert into messages(body, created_at, user_messages, client_id, reply_to)
+			values($1, $2, $3, $4, $5)
+			on conflict (user_messages, client_id) where client_id is not null do nothing
+			returning id, created_at`,
+			msg.Body, time.Now(), u.User.ID, msg.ClientID, msg.ReplyTo).Scan(&msg.ID, &msg.CreatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// another session stored the same message meanwhile
+			duplicate = true
+			_, err = storedMessage(tx, *u.User.ID, *msg.ClientID, msg)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		// the chat row stays locked until commit, so sequences are committed in order
+		err = tx.QueryRow(context.Background(), `update chats set last_seq = last_seq + 1 where id = $1 returning last_seq`,
+			*msg.ChatID).Scan(&msg.Seq)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(context.Background(), `insert into chat_messages(chat_id, message_id, seq) values($1, $2, $3)`,
+			*msg.ChatID, *msg.ID, *msg.Seq)
+		if err != nil {
+			return err
+		}
+
+		// the chat comes back for members who filed it away, unless they muted it
+		_, err = tx.Exec(context.Background(), `update chat_members set archived = false, hidden = false
+			where chat_id = $1 and (archived or hidden) and (muted_until is null or muted_until <= now())`, *msg.ChatID)
+		if err != nil {
+			return err
+		}
+
+		msg.Mentions, err = storeMentions(context.Background(), tx, *msg.ChatID, *msg.ID, *u.User.ID, *msg.Body)
+		if err != nil {
+			return err
+		}
 
-				return nil
+		if msg.Attachment != nil {
+			err = attach(context.Background(), tx, *msg.ChatID, *msg.ID, *u.User.ID, msg.Attachment)
+			if err != nil {
+				return err
+			}
+		}
 
-			})
+		// version 0 clients know a direct chat by its receiver, even when sent to the chat
+		if msg.Receiver == nil {
+			err = tx.QueryRow(context.Background(), `select `+directReceiver("$1", "$2"),
+				*msg.ChatID, *u.User.ID).Scan(&msg.Receiver)
 			if err != nil {
 				return err
 			}
+		}
+
+		members, err = ChatMembers(context.Background(), tx, *msg.ChatID)
+		return err
+	})
+
+	return duplicate, members, err
+}
+
+// storedMessage looks up a message of the user by its client id
+func storedMessage(tx pgx.Tx, userID uint, clientID string, msg *models.Message) (bool, error) {
+	err := tx.QueryRow(context.Background(), `select m.id, m.created_at, cm.chat_id, cm.seq from messages m
+		inner join chat_messages cm on cm.message_id = m.id
+		where m.user_messages = $1 and m.client_id = $2`, userID, clientID).
+		Scan(&msg.ID, &msg.CreatedAt, &msg.ChatID, &msg.Seq)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+/*
+directChat returns the two-person chat between the user and the recipient,
+creating it when they have never talked before. Users blocked either way get
+none, and neither do strangers when only contacts may talk.
+*/
+func directChat(tx pgx.Tx, userID uint, recipient string, contactsOnly bool) (*int, error) {
+	// check if recipient exists
+	var recipientID *uint
+	err := tx.QueryRow(context.Background(), `select id from users where username = $1`, recipient).Scan(&recipientID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var blocked, contact bool
+	err = tx.QueryRow(context.Background(), `select `+blockedBetween("$1", "$2")+`,
+		exists(select 1 from contacts where user_id = $1 and contact_id = $2)`, userID, *recipientID).
+		Scan(&blocked, &contact)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, ErrBlocked
+	}
+	if contactsOnly && !contact && *recipientID != userID {
+		return nil, ErrNotContact
+	}
+
+	// check chat between users was already created
+	var chatID *int
+	err = tx.QueryRow(context.Background(), `with user_chats as (
+												select cm.chat_id from chat_members cm
+												inner join chats c on c.id = cm.chat_id
+												where cm.user_id = $1 and c.type = $3
+											) select uc.chat_id from user_chats uc
+											inner join chat_members cm on cm.chat_id  = uc.chat_id
+											where cm.user_id = $2`, *recipientID, userID, ChatTypeDirect).Scan(&chatID)
+	if err == nil {
+		return chatID, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	// create chat
+	err = tx.QueryRow(context.Background(), `insert into chats(type) values($1) returning id`, ChatTypeDirect).Scan(&chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(context.Background(), `insert into chat_members(chat_id, user_id) values($1, $2)`, *chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(context.Background(), `insert into chat_members(chat_id, user_id) values($1, $2)`, *chatID, *recipientID)
+	if err != nil {
+		return nil, err
+	}
+
+	return chatID, nil
+}
+
+/*
+Send queues the message for the write pump without blocking. When the queue
+is full the frame is dropped or the client disconnected, depending on the
+overflow policy. While the client resumes the message is held instead.
+*/
+func (u *Connection) Send(frame *models.Envelope) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.holding {
+		if len(u.held) < cap(u.send) {
+			u.held = append(u.held, frame)
+		} else {
+			u.overflow()
+		}
+		return
+	}
+	u.enqueue(frame)
+}
+
+func (u *Connection) enqueue(frame *models.Envelope) {
+	select {
+	case <-u.done:
+		return
+	default:
+	}
+
+	b, ok := u.encode(frame)
+	if !ok {
+		return
+	}
+
+	select {
+	case u.send <- b:
+		if m := frame.Message; u.liveFrom != nil && m != nil && m.ChatID != nil && m.Seq != nil {
+			if _, ok := u.liveFrom[*m.ChatID]; !ok {
+				u.liveFrom[*m.ChatID] = *m.Seq
+			}
+		}
+		return
+	default:
+	}
+
+	u.overflow()
+}
+
+// sendWait queues the message, waiting for room instead of dropping it
+func (u *Connection) sendWait(frame *models.Envelope) bool {
+	b, ok := u.encode(frame)
+	if !ok {
+		return true
+	}
 
-			u.SocketManager.Messages <- &models.Message{
-				Body:     msgSerialized.Body,
-				Sender:   msgSerialized.Sender,
-				Receiver: msgSerialized.Receiver,
-				ChatID:   msgSerialized.ChatID,
+	select {
+	case u.send <- b:
+		return true
+	case <-u.done:
+		return false
+	}
+}
+
+// overflow applies the overflow policy to a frame that did not fit
+func (u *Connection) overflow() {
+	u.Dropped.Add(1)
+	u.SocketManager.dropped.Add(1)
+	if u.SocketManager.config.Overflow == OverflowDisconnect {
+		log.Printf("Evicting slow session %s of %s", u.SessionID, *u.User.Username)
+		u.SocketManager.evicted.Add(1)
+		// Listen fails on the closed socket and the session leaves
+		go u.Close(websocket.CloseTryAgainLater, "client too slow")
+		return
+	}
+	log.Printf("Dropped frame for slow session %s of %s", u.SessionID, *u.User.Username)
+}
+
+/*
+writePump writes queued frames to the socket and pings the client until the
+connection is done.
+*/
+func (u *Connection) writePump() {
+	config := u.SocketManager.config
+	ticker := time.NewTicker(config.PingInterval)
+	defer ticker.Stop()
+
+	messageType := websocket.TextMessage
+	if u.Version > 0 && u.Codec.Binary() {
+		messageType = websocket.BinaryMessage
+	}
+
+	for {
+		select {
+		case b := <-u.send:
+			_ = u.Conn.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+			if err := u.Conn.WriteMessage(messageType, b); err != nil {
+				log.Println("Error on write message:", err.Error())
+				u.Conn.Close()
+				return
 			}
+		case <-ticker.C:
+			if err := u.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(config.WriteTimeout)); err != nil {
+				log.Printf("Error on ping [session %s]: %s", u.SessionID, err.Error())
+				u.Conn.Close()
+				return
+			}
+		case <-u.done:
+			return
 		}
 	}
 }
 
-func (u *Connection) Send(message *models.Message) {
-	b, _ := json.Marshal(message)
+/*
+encode serializes the frame in the version and codec agreed with the client.
+Version 0 is always json, and frames it has no counterpart for are left out.
+*/
+func (u *Connection) encode(frame *models.Envelope) ([]byte, bool) {
+	if u.Version == 0 {
+		legacy := models.NewLegacyFrame(frame)
+		if legacy == nil {
+			return nil, false
+		}
+		b, err := json.Marshal(legacy)
+		return b, err == nil
+	}
 
-	if err := u.Conn.WriteMessage(websocket.TextMessage, b); err != nil {
-		log.Println("Error on write message:", err.Error())
+	b, err := u.Codec.Marshal(*frame)
+	if err != nil {
+		log.Printf("Frame %s not encoded: %v", frame.Type, err)
+		return nil, false
 	}
+	return b, true
+}
+
+// decode reads a frame in the version agreed with the client
+func (u *Connection) decode(data []byte) (*models.Envelope, error) {
+	if u.Version == 0 {
+		legacy := &models.LegacyFrame{}
+		if err := json.Unmarshal(data, legacy); err != nil {
+			return nil, err
+		}
+		return legacy.Envelope(), nil
+	}
+	return u.Codec.Unmarshal(data)
+}
+
+// Close sends a close frame with the code to the client and closes the socket
+func (u *Connection) Close(code int, text string) {
+	message := websocket.FormatCloseMessage(code, text)
+	_ = u.Conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	u.Conn.Close()
 }
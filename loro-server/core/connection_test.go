@@ -0,0 +1,31 @@
+package core_test
+
+import (
+	"server/core"
+	"server/db/utils"
+	"server/models"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendDropsWhenQueueIsFull(t *testing.T) {
+	config := core.DefaultConfig()
+	config.SendQueue = 1
+	config.Overflow = core.OverflowDrop
+	sm := core.NewSocketManager(core.NewLocalBroker(), newStorage(t), config)
+
+	username := "noname"
+	con := core.NewConnection(&utils.User{Username: &username}, nil, sm, nil, core.NewSessionID(), "test")
+
+	body := "hello"
+	frame := &models.Envelope{Type: models.TypeMessage, Message: &models.Message{Body: &body}}
+	con.Send(frame)
+	require.Equal(t, uint64(0), con.Dropped.Load())
+
+	// nobody drains the queue so the second frame is dropped
+	con.Send(frame)
+	require.Equal(t, uint64(1), con.Dropped.Load())
+	require.Equal(t, uint64(1), sm.Stats().DroppedFrames)
+	require.Equal(t, uint64(0), sm.Stats().Evicted)
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"context"
+	"errors"
+
+	"server/db"
+	"server/db/utils"
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrContactSelf     = errors.New("users cannot add themselves as contacts")
+	ErrNotContact      = errors.New("direct messages are only allowed between contacts")
+	ErrRequestNotFound = errors.New("contact request not found")
+	ErrContactNotFound = errors.New("contact not found")
+)
+
+// directStranger reports whether the chat is a direct chat whose other member is not a contact of the user
+func directStranger(ctx context.Context, q querier, chatID int, userID uint) (bool, error) {
+	var stranger bool
+	err := q.QueryRow(ctx, `select exists(select 1 from chats c
+		inner join chat_members cm on cm.chat_id = c.id and cm.user_id <> $2
+		where c.id = $1 and c.type = $3
+		and not exists(select 1 from contacts ct where ct.user_id = $2 and ct.contact_id = cm.user_id))`,
+		chatID, userID, ChatTypeDirect).Scan(&stranger)
+	return stranger, err
+}
+
+// otherUser looks up the user named for a contact change of the user
+func otherUser(ctx context.Context, q querier, user *utils.User, username string) (uint, error) {
+	if username == *user.Username {
+		return 0, ErrContactSelf
+	}
+	var otherID uint
+	err := q.QueryRow(ctx, `select id from users where username = $1`, username).Scan(&otherID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrUserNotFound
+	}
+	return otherID, err
+}
+
+// addContacts stores the users as contacts of one another
+func addContacts(ctx context.Context, tx pgx.Tx, a, b uint) error {
+	_, err := tx.Exec(ctx, `insert into contacts(user_id, contact_id) values($1, $2), ($2, $1)
+		on conflict (user_id, contact_id) do nothing`, a, b)
+	return err
+}
+
+/*
+RequestContact asks the username to become a contact of the user. When they
+asked the user already, both become contacts right away. Asking again or
+asking a contact changes nothing and sends nothing, and users blocked either
+way cannot ask.
+*/
+func RequestContact(pool *db.PostgresPool, sm *SocketManager, user *utils.User, username string) (*models.Contact, error) {
+	contact := &models.Contact{From: *user.Username, To: username, Status: models.ContactRequested}
+	changed := false
+	err := pool.Transaction(context.Background(), func(tx pgx.Tx) error {
+		otherID, err := otherUser(context.Background(), tx, user, username)
+		if err != nil {
+			return err
+		}
+
+		var blocked, contacts, asked bool
+		err = tx.QueryRow(context.Background(), `select `+blockedBetween("$1", "$2")+`,
+			exists(select 1 from contacts where user_id = $1 and contact_id = $2),
+			exists(select 1 from contact_requests where from_id = $2 and to_id = $1)`,
+			*user.ID, otherID).Scan(&blocked, &contacts, &asked)
+		if err != nil {
+			return err
+		}
+		switch {
+		case blocked:
+			return ErrBlocked
+		case contacts:
+			contact.Status = models.ContactAccepted
+			return nil
+		case asked:
+			// the request of the other user is accepted instead
+			contact.From, contact.To, contact.Status = username, *user.Username, models.ContactAccepted
+			_, err = tx.Exec(context.Background(), `delete from contact_requests where from_id = $1 and to_id = $2`,
+				otherID, *user.ID)
+			if err != nil {
+				return err
+			}
+			changed = true
+			return addContacts(context.Background(), tx, *user.ID, otherID)
+		}
+
+		tag, err := tx.Exec(context.Background(), `insert into contact_requests(from_id, to_id) values($1, $2)
+			on conflict (from_id, to_id) do nothing`, *user.ID, otherID)
+		changed = tag.RowsAffected() > 0
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if changed {
+		sendContact(sm, contact)
+	}
+	return contact, nil
+}
+
+// AcceptContact accepts the request the username sent to the user, they become contacts of one another
+func AcceptContact(pool *db.PostgresPool, sm *SocketManager, user *utils.User, username string) (*models.Contact, error) {
+	contact := &models.Contact{From: username, To: *user.Username, Status: models.ContactAccepted}
+	err := pool.Transaction(context.Background(), func(tx pgx.Tx) error {
+		otherID, err := otherUser(context.Background(), tx, user, username)
+		if err != nil {
+			return err
+		}
+
+		tag, err := tx.Exec(context.Background(), `delete from contact_requests where from_id = $1 and to_id = $2`,
+			otherID, *user.ID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrRequestNotFound
+		}
+		return addContacts(context.Background(), tx, *user.ID, otherID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sendContact(sm, contact)
+	return contact, nil
+}
+
+// DeclineContact declines the request the username sent to the user, or withdraws the one the user sent them
+func DeclineContact(pool *db.PostgresPool, sm *SocketManager, user *utils.User, username string) (*models.Contact, error) {
+	otherID, err := otherUser(context.Background(), pool, user, username)
+	if err != nil {
+		return nil, err
+	}
+
+	contact := &models.Contact{Status: models.ContactDeclined}
+	var fromID uint
+	err = pool.QueryRow(context.Background(), `delete from contact_requests
+		where from_id = $1 and to_id = $2 or from_id = $2 and to_id = $1 returning from_id`,
+		*user.ID, otherID).Scan(&fromID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	contact.From, contact.To = *user.Username, username
+	if fromID == otherID {
+		contact.From, contact.To = username, *user.Username
+	}
+
+	sendContact(sm, contact)
+	return contact, nil
+}
+
+// RemoveContact makes the user and the username contacts of one another no more
+func RemoveContact(pool *db.PostgresPool, sm *SocketManager, user *utils.User, username string) (*models.Contact, error) {
+	otherID, err := otherUser(context.Background(), pool, user, username)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := pool.Execute(context.Background(), `delete from contacts
+		where user_id = $1 and contact_id = $2 or user_id = $2 and contact_id = $1`, *user.ID, otherID)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrContactNotFound
+	}
+
+	contact := &models.Contact{From: *user.Username, To: username, Status: models.ContactRemoved}
+	sendContact(sm, contact)
+	return contact, nil
+}
+
+func sendContact(sm *SocketManager, contact *models.Contact) {
+	sm.Messages <- &Delivery{
+		Recipients: []string{contact.From, contact.To},
+		Frame:      &models.Envelope{Type: models.TypeContact, Contact: contact},
+	}
+}
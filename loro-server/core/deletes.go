@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"server/db"
+	"server/db/utils"
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrRetractWindow = errors.New("message is too old to be deleted for everyone")
+
+/*
+RetractMessage deletes a message of the user for every member. The message
+stays as a tombstone without body, revisions or file so pages keep their
+size, and the retract is sent to every member. Deleting a tombstone again
+changes nothing. The socket manager's RetractWindow limits how old the
+message may be.
+*/
+func RetractMessage(pool *db.PostgresPool, sm *SocketManager, user *utils.User, chatID, messageID int) (*models.Retract, error) {
+	retract := &models.Retract{ChatID: chatID, MessageID: messageID, ForEveryone: true}
+	var members []string
+	var file *string
+	err := pool.Transaction(context.Background(), func(tx pgx.Tx) error {
+		ok, err := IsMember(context.Background(), tx, chatID, *user.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNotMember
+		}
+
+		var senderID *uint
+		var createdAt time.Time
+		err = tx.QueryRow(context.Background(), `select m.user_messages, m.created_at, m.deleted_at
+			from messages m inner join chat_messages cm on cm.message_id = m.id
+			where cm.chat_id = $1 and m.id = $2 for update of m`, chatID, messageID).
+			Scan(&senderID, &createdAt, &retract.DeletedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrMessageNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if senderID == nil || *senderID != *user.ID {
+			return ErrNotSender
+		}
+		if retract.DeletedAt != nil {
+			return nil
+		}
+		if window := sm.config.RetractWindow; window > 0 && time.Since(createdAt) > window {
+			return ErrRetractWindow
+		}
+
+		_, err = tx.Exec(context.Background(), `delete from message_revisions where message_id = $1`, messageID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(context.Background(), `delete from message_reactions where message_id = $1`, messageID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(context.Background(), `delete from message_mentions where message_id = $1`, messageID)
+		if err != nil {
+			return err
+		}
+		// members drop the pin when they get the retract
+		_, err = tx.Exec(context.Background(), `delete from pinned_messages where message_id = $1`, messageID)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRow(context.Background(), `delete from attachments where message_id = $1 returning storage_key`,
+			messageID).Scan(&file)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+
+		err = tx.QueryRow(context.Background(), `update messages set body = '', deleted_at = now() where id = $1
+			returning deleted_at`, messageID).Scan(&retract.DeletedAt)
+		if err != nil {
+			return err
+		}
+
+		members, err = ChatMembers(context.Background(), tx, chatID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if file != nil {
+		// the row is gone already, a file left behind is only wasted space
+		if err := sm.storage.Delete(context.Background(), *file); err != nil {
+			log.Printf("File of retracted message %d not deleted: %v", messageID, err)
+		}
+	}
+
+	if len(members) > 0 {
+		sm.Messages <- &Delivery{
+			Recipients: members,
+			Frame:      &models.Envelope{Type: models.TypeRetract, Retract: retract},
+		}
+		sendUnread(pool, sm, chatID, others(members, *user.Username))
+	}
+	return retract, nil
+}
+
+/*
+HideMessage deletes a message for the user only, any message of a chat they
+belong to can be hidden. The other sessions of the user are told.
+*/
+func HideMessage(pool *db.PostgresPool, sm *SocketManager, user *utils.User, chatID, messageID int) (*models.Retract, error) {
+	ok, err := IsMember(context.Background(), pool, chatID, *user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotMember
+	}
+
+	retract := &models.Retract{ChatID: chatID, MessageID: messageID}
+	err = pool.QueryRow(context.Background(), `insert into hidden_messages(user_id, message_id)
+		select $1, cm.message_id from chat_messages cm where cm.chat_id = $2 and cm.message_id = $3
+		on conflict (user_id, message_id) do update set hidden_at = hidden_messages.hidden_at
+		returning hidden_at`, *user.ID, chatID, messageID).Scan(&retract.DeletedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sm.Messages <- &Delivery{
+		Recipients: []string{*user.Username},
+		Frame:      &models.Envelope{Type: models.TypeRetract, Retract: retract},
+	}
+	sendUnread(pool, sm, chatID, []string{*user.Username})
+	return retract, nil
+}
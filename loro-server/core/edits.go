@@ -0,0 +1,116 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"server/db"
+	"server/db/utils"
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	ErrNotSender       = errors.New("only the sender can change the message")
+	ErrEmptyMessage    = errors.New("message is empty")
+)
+
+/*
+EditMessage replaces the body of a message sent by the user, keeps the body
+it had as a revision and sends the edit to every member. Mentions follow the
+new body and members it names for the first time are notified. Senders who
+left the chat can no longer edit there.
+*/
+func EditMessage(pool *db.PostgresPool, sm *SocketManager, user *utils.User, chatID, messageID int, body string) (*models.Edit, error) {
+	if strings.TrimSpace(body) == "" {
+		return nil, ErrEmptyMessage
+	}
+
+	edit := &models.Edit{ChatID: chatID, MessageID: messageID, Body: body}
+	mention := &models.Mention{ChatID: chatID, MessageID: messageID, Sender: *user.Username, Body: body}
+	var members, mentioned []string
+	err := pool.Transaction(context.Background(), func(tx pgx.Tx) error {
+		ok, err := IsMember(context.Background(), tx, chatID, *user.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNotMember
+		}
+
+		var senderID *uint
+		var previous string
+		var writtenAt time.Time
+		err = tx.QueryRow(context.Background(), `select m.user_messages, m.body, coalesce(m.edited_at, m.created_at),
+			m.created_at, cm.seq
+			from messages m inner join chat_messages cm on cm.message_id = m.id
+			where cm.chat_id = $1 and m.id = $2 and m.deleted_at is null for update of m`, chatID, messageID).
+			Scan(&senderID, &previous, &writtenAt, &mention.CreatedAt, &mention.Seq)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrMessageNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if senderID == nil || *senderID != *user.ID {
+			return ErrNotSender
+		}
+
+		_, err = tx.Exec(context.Background(), `insert into message_revisions(message_id, body, written_at) values($1, $2, $3)`,
+			messageID, previous, writtenAt)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(context.Background(), `update messages set body = $2, edited_at = now() where id = $1
+			returning edited_at`, messageID, body).Scan(&edit.EditedAt)
+		if err != nil {
+			return err
+		}
+
+		mentioned, err = updateMentions(context.Background(), tx, chatID, messageID, *user.ID, body)
+		if err != nil {
+			return err
+		}
+
+		members, err = ChatMembers(context.Background(), tx, chatID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sm.Messages <- &Delivery{
+		Recipients: members,
+		Sender:     *user.Username,
+		Frame:      &models.Envelope{Type: models.TypeEdit, Edit: edit},
+	}
+	sendMention(sm, mention, mentioned)
+	return edit, nil
+}
+
+// Revisions returns the previous bodies of the message, oldest first
+func Revisions(pool *db.PostgresPool, chatID, messageID int) ([]utils.Revision, error) {
+	rows, err := pool.Query(context.Background(), `select r.body, r.written_at from message_revisions r
+		inner join chat_messages cm on cm.message_id = r.message_id
+		where cm.chat_id = $1 and r.message_id = $2 order by r.id`, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]utils.Revision, 0)
+	for rows.Next() {
+		revision := utils.Revision{}
+		if err := rows.Scan(&revision.Body, &revision.WrittenAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, rows.Err()
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// other instances may change members, so entries are trusted for a while only
+const membersTTL = time.Minute
+
+/*
+memberCache keeps the members of recently active chats so ephemeral events
+such as typing can be routed without going to the database.
+*/
+type memberCache struct {
+	mu    sync.Mutex
+	chats map[int]cachedMembers
+}
+
+type cachedMembers struct {
+	usernames []string
+	expires   time.Time
+}
+
+func newMemberCache() *memberCache {
+	return &memberCache{chats: make(map[int]cachedMembers)}
+}
+
+func (c *memberCache) get(chatID int) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.chats[chatID]
+	if !ok || time.Now().After(cached.expires) {
+		delete(c.chats, chatID)
+		return nil, false
+	}
+	return cached.usernames, true
+}
+
+func (c *memberCache) put(chatID int, usernames []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.chats[chatID] = cachedMembers{usernames: usernames, expires: time.Now().Add(membersTTL)}
+}
+
+func (c *memberCache) forget(chatID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.chats, chatID)
+}
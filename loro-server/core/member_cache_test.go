@@ -0,0 +1,31 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemberCache(t *testing.T) {
+	cache := newMemberCache()
+
+	_, ok := cache.get(1)
+	require.False(t, ok)
+
+	cache.put(1, []string{"ana", "bob"})
+	members, ok := cache.get(1)
+	require.True(t, ok)
+	require.Equal(t, []string{"ana", "bob"}, members)
+
+	cache.forget(1)
+	_, ok = cache.get(1)
+	require.False(t, ok)
+
+	// entries other instances may have changed meanwhile are dropped
+	cache.put(2, []string{"ana"})
+	cache.chats[2] = cachedMembers{usernames: []string{"ana"}, expires: time.Now().Add(-time.Second)}
+	_, ok = cache.get(2)
+	require.False(t, ok)
+	require.NotContains(t, cache.chats, 2)
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// parseMentions returns the usernames written as @username in the body, each once
+func parseMentions(body string) []string {
+	usernames := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, word := range strings.Fields(body) {
+		if !strings.HasPrefix(word, "@") {
+			continue
+		}
+		// "@ana," and "@ana!" name ana too
+		username := strings.TrimRightFunc(word[1:], unicode.IsPunct)
+		if username == "" || seen[username] {
+			continue
+		}
+		seen[username] = true
+		usernames = append(usernames, username)
+	}
+	return usernames
+}
+
+/*
+storeMentions keeps who a group message names and returns the usernames not
+named before. Names of people outside the chat, the sender and messages of
+direct chats are ignored.
+*/
+func storeMentions(ctx context.Context, tx pgx.Tx, chatID, messageID int, senderID uint, body string) ([]string, error) {
+	usernames := parseMentions(body)
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(ctx, `insert into message_mentions(message_id, user_id)
+		select $1, u.id from users u
+		inner join chat_members cm on cm.user_id = u.id and cm.chat_id = $2
+		inner join chats c on c.id = cm.chat_id and c.type = $5
+		where u.username = any($3) and u.id != $4
+		on conflict (message_id, user_id) do nothing
+		returning (select username from users where id = user_id)`,
+		messageID, chatID, usernames, senderID, ChatTypeGroup)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentioned := make([]string, 0)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		mentioned = append(mentioned, username)
+	}
+	return mentioned, rows.Err()
+}
+
+/*
+updateMentions rewrites who an edited message names and returns the usernames
+it names for the first time. Members still named keep whether they read it.
+*/
+func updateMentions(ctx context.Context, tx pgx.Tx, chatID, messageID int, senderID uint, body string) ([]string, error) {
+	_, err := tx.Exec(ctx, `delete from message_mentions mm using users u
+		where mm.message_id = $1 and u.id = mm.user_id and not u.username = any($2)`, messageID, parseMentions(body))
+	if err != nil {
+		return nil, err
+	}
+	return storeMentions(ctx, tx, chatID, messageID, senderID, body)
+}
+
+// sendMention notifies the usernames named in a message, even those who muted the chat
+func sendMention(sm *SocketManager, mention *models.Mention, usernames []string) {
+	if len(usernames) == 0 {
+		return
+	}
+
+	sm.Messages <- &Delivery{
+		Recipients: usernames,
+		Sender:     mention.Sender,
+		Frame:      &models.Envelope{Type: models.TypeMention, Mention: mention},
+	}
+}
@@ -0,0 +1,25 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMentions(t *testing.T) {
+	for _, tc := range []struct {
+		body string
+		want []string
+	}{
+		{"no one here", []string{}},
+		{"@ana look", []string{"ana"}},
+		{"hi @ana, @bob!", []string{"ana", "bob"}},
+		{"@ana and @ana again", []string{"ana"}},
+		{"mail ana@loro.chat", []string{}},
+		{"just @ and @, alone", []string{}},
+		{"@ana.\n@bob?", []string{"ana", "bob"}},
+		{"@ana_b @ana-b", []string{"ana_b", "ana-b"}},
+	} {
+		require.Equal(t, tc.want, parseMentions(tc.body), tc.body)
+	}
+}
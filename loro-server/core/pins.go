@@ -0,0 +1,128 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"server/db"
+	"server/db/utils"
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// a chat keeps this many pins at most
+const maxPins = 10
+
+var ErrTooManyPins = fmt.Errorf("a chat has at most %d pinned messages", maxPins)
+
+/*
+PinMessage pins a message to the top of its chat or unpins it, any member may
+do both. The change is sent to every member, pinning a pinned message or
+unpinning one that is not pinned changes nothing and sends nothing.
+*/
+func PinMessage(pool *db.PostgresPool, sm *SocketManager, user *utils.User, chatID, messageID int, pinned bool) (*models.Pin, error) {
+	pin := &models.Pin{ChatID: chatID, MessageID: messageID, Pinned: pinned, Username: *user.Username}
+	var members []string
+	err := pool.Transaction(context.Background(), func(tx pgx.Tx) error {
+		ok, err := IsMember(context.Background(), tx, chatID, *user.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNotMember
+		}
+
+		if !pinned {
+			tag, err := tx.Exec(context.Background(), `delete from pinned_messages where chat_id = $1 and message_id = $2`,
+				chatID, messageID)
+			if err != nil || tag.RowsAffected() == 0 {
+				return err
+			}
+			members, err = ChatMembers(context.Background(), tx, chatID)
+			return err
+		}
+
+		// the chat row stays locked until commit, so the limit holds for concurrent pins
+		_, err = tx.Exec(context.Background(), `select 1 from chats where id = $1 for update`, chatID)
+		if err != nil {
+			return err
+		}
+		var count int
+		err = tx.QueryRow(context.Background(), `select count(*) from pinned_messages where chat_id = $1`,
+			chatID).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(context.Background(), `select u.username, m.body from messages m
+			inner join chat_messages cm on cm.message_id = m.id
+			left join users u on u.id = m.user_messages
+			where cm.chat_id = $1 and m.id = $2 and m.deleted_at is null`, chatID, messageID).
+			Scan(&pin.Sender, &pin.Body)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrMessageNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(context.Background(), `insert into pinned_messages(chat_id, message_id, pinned_by)
+			values($1, $2, $3) on conflict (chat_id, message_id) do nothing returning pinned_at`,
+			chatID, messageID, *user.ID).Scan(&pin.PinnedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// checked once the pin is known to be new, so pinning again stays harmless at the limit
+		if count >= maxPins {
+			return ErrTooManyPins
+		}
+
+		members, err = ChatMembers(context.Background(), tx, chatID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(members) > 0 {
+		sm.Messages <- &Delivery{
+			Recipients: members,
+			Frame:      &models.Envelope{Type: models.TypePin, Pin: pin},
+		}
+	}
+	return pin, nil
+}
+
+// Pins returns the pinned messages of the chat, the latest pinned first
+func Pins(pool *db.PostgresPool, chatID int) ([]models.Pin, error) {
+	rows, err := pool.Query(context.Background(), `select p.message_id, pu.username, u.username, m.body, p.pinned_at
+		from pinned_messages p
+		inner join messages m on m.id = p.message_id
+		left join users u on u.id = m.user_messages
+		left join users pu on pu.id = p.pinned_by
+		where p.chat_id = $1 order by p.pinned_at desc`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := make([]models.Pin, 0)
+	for rows.Next() {
+		pin := models.Pin{ChatID: chatID, Pinned: true}
+		var pinnedBy *string
+		if err := rows.Scan(&pin.MessageID, &pinnedBy, &pin.Sender, &pin.Body, &pin.PinnedAt); err != nil {
+			return nil, err
+		}
+		if pinnedBy != nil {
+			pin.Username = *pinnedBy
+		}
+		pins = append(pins, pin)
+	}
+
+	return pins, rows.Err()
+}
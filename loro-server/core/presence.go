@@ -0,0 +1,115 @@
+package core
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"server/db"
+	"server/models"
+)
+
+/*
+Contacts returns the users sharing at least one chat with the user and the
+contacts of the user, but none blocked either way.
+*/
+func Contacts(ctx context.Context, q querier, userID uint) ([]string, error) {
+	rows, err := q.Query(ctx, `select u.username from users u where u.id in (
+			select cm.user_id from chat_members me
+			inner join chat_members cm on cm.chat_id = me.chat_id and cm.user_id <> me.user_id
+			where me.user_id = $1
+			union select contact_id from contacts where user_id = $1
+		) and not `+blockedBetween("$1", "u.id"), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := make([]string, 0)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, username)
+	}
+
+	return contacts, rows.Err()
+}
+
+/*
+Presence returns whether each of the usernames is online and when they were
+last seen. Users who share no chat with the user and are not their contacts
+are left out, so strangers cannot be watched, and so are users blocked either
+way. Users are online with a session on any instance.
+*/
+func Presence(pool *db.PostgresPool, sm *SocketManager, userID uint, usernames []string) ([]models.Presence, error) {
+	rows, err := pool.Query(context.Background(), `select u.username, u.last_seen_at from users u
+		where u.username = any($2) and (u.id = $1 or (exists(select 1 from chat_members me
+			inner join chat_members cm on cm.chat_id = me.chat_id
+			where me.user_id = $1 and cm.user_id = u.id)
+			or exists(select 1 from contacts where user_id = $1 and contact_id = u.id))
+			and not `+blockedBetween("$1", "u.id")+`)`, userID, usernames)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	presence := make([]models.Presence, 0, len(usernames))
+	for rows.Next() {
+		p := models.Presence{}
+		if err := rows.Scan(&p.Username, &p.LastSeenAt); err != nil {
+			return nil, err
+		}
+		presence = append(presence, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(presence))
+	for _, p := range presence {
+		names = append(names, p.Username)
+	}
+	online := sm.Online(names)
+	for i := range presence {
+		presence[i].Online = online[presence[i].Username]
+	}
+	return presence, nil
+}
+
+/*
+announce stores when the user was last seen and tells their contacts whether
+they are online. It runs outside of the hub loop because it queries the
+database, so by the time it is done the user may have come or gone again and
+then the newer announcement wins. A user who left this instance but still has
+a session on another one stays online.
+*/
+func (sm *SocketManager) announce(con *Connection, online bool) {
+	ctx := context.Background()
+	var lastSeen time.Time
+	err := con.Pool.QueryRow(ctx, `update users set last_seen_at = now() where id = $1 returning last_seen_at`,
+		*con.User.ID).Scan(&lastSeen)
+	if err != nil {
+		log.Printf("Last seen of %s not stored: %v", *con.User.Username, err)
+		return
+	}
+
+	contacts, err := Contacts(ctx, con.Pool, *con.User.ID)
+	if err != nil {
+		log.Printf("Presence of %s not sent: %v", *con.User.Username, err)
+		return
+	}
+	if len(contacts) == 0 || sm.Online([]string{*con.User.Username})[*con.User.Username] != online {
+		return
+	}
+
+	sm.Messages <- &Delivery{
+		Recipients: contacts,
+		Frame: &models.Envelope{Type: models.TypePresence, Presence: &models.Presence{
+			Username:   *con.User.Username,
+			Online:     online,
+			LastSeenAt: &lastSeen,
+		}},
+	}
+}
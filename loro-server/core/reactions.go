@@ -0,0 +1,190 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"server/db"
+	"server/db/utils"
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// long enough for emoji joined from several code points
+const maxEmojiLength = 16
+
+var ErrInvalidReaction = errors.New("reaction must be a single emoji")
+
+// Code points an emoji is built around, pictographs and the symbols shown as emoji
+var emojiBases = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00a9, Hi: 0x00ae, Stride: 5},
+		{Lo: 0x203c, Hi: 0x2049, Stride: 13},
+		{Lo: 0x2122, Hi: 0x2139, Stride: 23},
+		{Lo: 0x2194, Hi: 0x21aa, Stride: 1},
+		{Lo: 0x231a, Hi: 0x23ff, Stride: 1},
+		{Lo: 0x24c2, Hi: 0x24c2, Stride: 1},
+		{Lo: 0x25aa, Hi: 0x25fe, Stride: 1},
+		{Lo: 0x2600, Hi: 0x27bf, Stride: 1},
+		{Lo: 0x2934, Hi: 0x2935, Stride: 1},
+		{Lo: 0x2b05, Hi: 0x2b55, Stride: 1},
+		{Lo: 0x3030, Hi: 0x303d, Stride: 13},
+		{Lo: 0x3297, Hi: 0x3299, Stride: 2},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f000, Hi: 0x1f1e5, Stride: 1},
+		{Lo: 0x1f200, Hi: 0x1f3fa, Stride: 1},
+		{Lo: 0x1f400, Hi: 0x1faff, Stride: 1},
+	},
+}
+
+// Code points that only make sense inside an emoji
+const (
+	zeroWidthJoiner    = '\u200d'
+	variationSelector  = '\ufe0f'
+	keycap             = '\u20e3'
+	regionalIndicatorA = 0x1f1e6
+	regionalIndicatorZ = 0x1f1ff
+	skinToneLight      = 0x1f3fb
+	skinToneDark       = 0x1f3ff
+	tagSpace           = 0xe0020
+	tagTilde           = 0xe007e
+	cancelTag          = 0xe007f
+)
+
+/*
+isEmoji reports whether s is a single emoji: a flag of two regional
+indicators, a keycap, or pictographs joined with zero width joiners, each
+maybe followed by a variation selector, a skin tone or a tag sequence.
+*/
+func isEmoji(s string) bool {
+	runes := []rune(s)
+	switch {
+	case len(runes) == 2 && isRegionalIndicator(runes[0]) && isRegionalIndicator(runes[1]):
+		return true
+	case len(runes) > 1 && runes[len(runes)-1] == keycap:
+		base := runes[:len(runes)-1]
+		if base[len(base)-1] == variationSelector {
+			base = base[:len(base)-1]
+		}
+		return len(base) == 1 && strings.ContainsRune("0123456789#*", base[0])
+	}
+
+	for i := 0; i < len(runes); i++ {
+		if !unicode.Is(emojiBases, runes[i]) {
+			return false
+		}
+		if i+1 < len(runes) && runes[i+1] == variationSelector {
+			i++
+		}
+		if i+1 < len(runes) && runes[i+1] >= skinToneLight && runes[i+1] <= skinToneDark {
+			i++
+		}
+		if i+1 < len(runes) && runes[i+1] >= tagSpace && runes[i+1] <= tagTilde {
+			for i++; i+1 < len(runes) && runes[i+1] != cancelTag; i++ {
+				if runes[i+1] < tagSpace || runes[i+1] > tagTilde {
+					return false
+				}
+			}
+			if i+1 == len(runes) {
+				return false
+			}
+			i++
+		}
+		if i+1 < len(runes) {
+			if runes[i+1] != zeroWidthJoiner || i+2 == len(runes) {
+				return false
+			}
+			i++
+		}
+	}
+	return len(runes) > 0
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= regionalIndicatorA && r <= regionalIndicatorZ
+}
+
+/*
+ReactionCounts is a column with the reactions on the message m as a json
+array of emoji and count, the most used first. Messages without reactions
+get an empty array.
+*/
+const ReactionCounts = `coalesce((select json_agg(json_build_object('emoji', r.emoji, 'count', r.count)
+	order by r.count desc, r.first)
+	from (select emoji, count(*) as count, min(created_at) as first from message_reactions
+	where message_id = m.id group by emoji) r), '[]')`
+
+/*
+ToggleReaction sets the emoji as the reaction of the user on a message, or
+removes it when the user already reacted with it. The change is sent to every
+member with the new counts. Deleted messages take no reactions.
+*/
+func ToggleReaction(pool *db.PostgresPool, sm *SocketManager, user *utils.User, chatID, messageID int, emoji string) (*models.Reaction, error) {
+	emoji = strings.TrimSpace(emoji)
+	if utf8.RuneCountInString(emoji) > maxEmojiLength || !isEmoji(emoji) {
+		return nil, ErrInvalidReaction
+	}
+
+	reaction := &models.Reaction{ChatID: chatID, MessageID: messageID, Username: *user.Username}
+	var members []string
+	err := pool.Transaction(context.Background(), func(tx pgx.Tx) error {
+		ok, err := IsMember(context.Background(), tx, chatID, *user.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNotMember
+		}
+
+		var found bool
+		err = tx.QueryRow(context.Background(), `select exists(select 1 from messages m
+			inner join chat_messages cm on cm.message_id = m.id
+			where cm.chat_id = $1 and m.id = $2 and m.deleted_at is null)`, chatID, messageID).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrMessageNotFound
+		}
+
+		tag, err := tx.Exec(context.Background(), `delete from message_reactions
+			where message_id = $1 and user_id = $2 and emoji = $3`, messageID, *user.ID, emoji)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			_, err = tx.Exec(context.Background(), `insert into message_reactions(message_id, user_id, emoji)
+				values ($1, $2, $3) on conflict (message_id, user_id)
+				do update set emoji = excluded.emoji, created_at = now()`, messageID, *user.ID, emoji)
+			if err != nil {
+				return err
+			}
+			reaction.Emoji = emoji
+		}
+
+		err = tx.QueryRow(context.Background(), `select `+ReactionCounts+` from messages m where m.id = $1`,
+			messageID).Scan(&reaction.Reactions)
+		if err != nil {
+			return err
+		}
+
+		members, err = ChatMembers(context.Background(), tx, chatID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(members) > 0 {
+		sm.Messages <- &Delivery{
+			Recipients: members,
+			Frame:      &models.Envelope{Type: models.TypeReaction, Reaction: reaction},
+		}
+	}
+	return reaction, nil
+}
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsEmoji(t *testing.T) {
+	for _, tc := range []struct {
+		emoji string
+		want  bool
+	}{
+		{"👍", true},
+		{"❤️", true},
+		{"👍🏽", true},
+		{"👩‍💻", true},
+		{"👨‍👩‍👧", true},
+		{"🇦🇷", true},
+		{"1️⃣", true},
+		{"🏴󠁧󠁢󠁳󠁣󠁴󠁿", true},
+		{"", false},
+		{"ok", false},
+		{"a", false},
+		{"1", false},
+		{"👍👍", false},
+		{"👍 ", false},
+		{"🇦", false},
+		{"👩‍", false},
+		{"x⃣", false},
+		{"🏽", false},
+	} {
+		require.Equal(t, tc.want, isEmoji(tc.emoji), tc.emoji)
+	}
+}
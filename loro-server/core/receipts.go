@@ -0,0 +1,99 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"server/db"
+	"server/db/utils"
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+/*
+MarkRead moves the read cursor of the user in the chat forward to the message
+and sends a receipt to every member. Cursors never move back, so reading an
+older message changes nothing. Mentions of the user up to the message are
+read too.
+*/
+func MarkRead(pool *db.PostgresPool, sm *SocketManager, user *utils.User, chatID, messageID int) error {
+	ok, err := IsMember(context.Background(), pool, chatID, *user.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotMember
+	}
+
+	// messages are ordered by their sequence in the chat, not by id
+	var seq int64
+	err = pool.QueryRow(context.Background(), `select seq from chat_messages where chat_id = $1 and message_id = $2`,
+		chatID, messageID).Scan(&seq)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	readAt := time.Now()
+	tag, err := pool.Execute(context.Background(), `update chat_members me set last_read_message_id = $3, last_read_at = $4
+		where me.chat_id = $1 and me.user_id = $2 and coalesce((select seq from chat_messages
+		where chat_id = me.chat_id and message_id = me.last_read_message_id), 0) < $5`,
+		chatID, *user.ID, messageID, readAt, seq)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	_, err = pool.Execute(context.Background(), `update message_mentions mm set read_at = $4
+		from chat_messages cm where cm.message_id = mm.message_id and cm.chat_id = $1
+		and mm.user_id = $2 and mm.read_at is null and cm.seq <= $3`,
+		chatID, *user.ID, seq, readAt)
+	if err != nil {
+		return err
+	}
+
+	members, err := ChatMembers(context.Background(), pool, chatID)
+	if err != nil {
+		return err
+	}
+
+	sm.Messages <- &Delivery{
+		Recipients: members,
+		Frame: &models.Envelope{Type: models.TypeReceipt, Receipt: &models.Receipt{
+			ChatID:    chatID,
+			MessageID: messageID,
+			Username:  *user.Username,
+			ReadAt:    readAt,
+		}},
+	}
+	// the other sessions of the reader show the chat as read
+	sendUnread(pool, sm, chatID, []string{*user.Username})
+	return nil
+}
+
+// ReadCursors returns the last message read by each member of the chat
+func ReadCursors(pool *db.PostgresPool, chatID int) ([]utils.ReadCursor, error) {
+	rows, err := pool.Query(context.Background(), `select u.username, cm.last_read_message_id, cm.last_read_at
+		from chat_members cm inner join users u on u.id = cm.user_id where cm.chat_id = $1`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cursors := make([]utils.ReadCursor, 0)
+	for rows.Next() {
+		cursor := utils.ReadCursor{}
+		if err := rows.Scan(&cursor.Username, &cursor.MessageID, &cursor.ReadAt); err != nil {
+			return nil, err
+		}
+		cursors = append(cursors, cursor)
+	}
+
+	return cursors, rows.Err()
+}
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"context"
+	"errors"
+
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+/*
+QuotedParent is a column with a quote of the message m answers as a json
+object, null when m is no reply. The quote keeps the first 80 characters of
+the body and has none once the parent was deleted.
+*/
+const QuotedParent = `(select json_build_object('id', p.id, 'sender', pu.username,
+	'body', case when p.deleted_at is null then left(p.body, 80) end, 'deleted', p.deleted_at is not null)
+	from messages p left join users pu on pu.id = p.user_messages where p.id = m.reply_to)`
+
+// quoteParent returns a quote of the message of the chat a reply answers
+func quoteParent(ctx context.Context, q querier, chatID, messageID int) (*models.Quote, error) {
+	var quote *models.Quote
+	err := q.QueryRow(ctx, `select `+QuotedParent+` from (select cm.message_id as reply_to from chat_messages cm
+		where cm.chat_id = $1 and cm.message_id = $2) m`, chatID, messageID).Scan(&quote)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	return quote, err
+}
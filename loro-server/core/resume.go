@@ -0,0 +1,179 @@
+package core
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"server/db"
+	"server/models"
+)
+
+const (
+	// most messages replayed per chat, a client further behind reloads the chat
+	maxReplay = 500
+	// live frames are not held longer than this for a resume that never comes
+	resumeWait = 10 * time.Second
+)
+
+/*
+HoldLive keeps frames from the socket manager away from a reconnecting client
+until it resumed, so the messages it missed reach it before the live ones.
+It must be called before the connection joins the socket manager.
+*/
+func (u *Connection) HoldLive() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.holding = true
+	u.holdTimer = time.AfterFunc(resumeWait, u.expireHold)
+}
+
+/*
+expireHold sends the held frames when no resume came in time. The messages
+sent live from then on are noted so a late resume does not send them again.
+*/
+func (u *Connection) expireHold() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// a resume that started owns the hold
+	if !u.holding || u.resuming {
+		return
+	}
+	u.liveFrom = make(map[int]int64)
+	u.flush(nil)
+}
+
+/*
+holdForResume holds live frames for the replay, whether or not the hold of
+HoldLive ran out already. It returns the first sequence sent live per chat
+since it ran out, the replay stops short of them.
+*/
+func (u *Connection) holdForResume() map[int]int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.holdTimer != nil {
+		u.holdTimer.Stop()
+	}
+	u.holding, u.resuming = true, true
+	live := u.liveFrom
+	u.liveFrom = nil
+	return live
+}
+
+// release ends the hold of a resume and sends the frames held meanwhile
+func (u *Connection) release(replayed map[int]int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.resuming = false
+	u.flush(replayed)
+}
+
+// flush sends the held frames, leaving out messages the replay already sent
+func (u *Connection) flush(replayed map[int]int64) {
+	if !u.holding {
+		return
+	}
+	u.holding = false
+	for _, frame := range u.held {
+		if m := frame.Message; m != nil && m.ChatID != nil && m.Seq != nil && *m.Seq <= replayed[*m.ChatID] {
+			continue
+		}
+		u.enqueue(frame)
+	}
+	u.held = nil
+}
+
+/*
+handleResume replays, in sequence order, the messages stored after the
+client's cursor of each chat and ends with a resumed frame. Chats the user
+does not belong to are skipped. A chat with more than maxReplay missed
+messages is only replayed up to there and listed as truncated.
+*/
+func (u *Connection) handleResume(resume *models.Resume) {
+	live := u.holdForResume()
+	replayed := make(map[int]int64)
+	truncated := make([]int, 0)
+	defer u.release(replayed)
+
+	for _, cursor := range resume.Cursors {
+		ok, err := IsMember(context.Background(), u.Pool, cursor.ChatID, *u.User.ID)
+		if err != nil {
+			log.Printf("Resume of chat %d for %s failed: %v", cursor.ChatID, *u.User.Username, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		messages, err := MissedMessages(u.Pool, *u.User.ID, cursor.ChatID, cursor.Seq, maxReplay+1)
+		if err != nil {
+			log.Printf("Resume of chat %d for %s failed: %v", cursor.ChatID, *u.User.Username, err)
+			continue
+		}
+		if len(messages) > maxReplay {
+			messages = messages[:maxReplay]
+			truncated = append(truncated, cursor.ChatID)
+		}
+
+		if !u.replay(cursor.ChatID, messages, live, replayed) {
+			return
+		}
+	}
+
+	u.sendWait(&models.Envelope{Type: models.TypeResumed, Resumed: &models.Resumed{Truncated: truncated}})
+}
+
+// replay sends the missed messages of the chat that were not sent live, false once the connection is done
+func (u *Connection) replay(chatID int, messages []*models.Message, live, replayed map[int]int64) bool {
+	for _, message := range messages {
+		if first, ok := live[chatID]; ok && *message.Seq >= first {
+			break
+		}
+		if !u.sendWait(&models.Envelope{Type: models.TypeMessage, Message: message}) {
+			return false
+		}
+		replayed[chatID] = *message.Seq
+	}
+	return true
+}
+
+/*
+MissedMessages returns up to limit messages of the chat after the sequence,
+oldest first. Messages deleted for everyone or hidden by the user come as
+tombstones.
+*/
+func MissedMessages(pool *db.PostgresPool, userID uint, chatID int, after int64, limit int) ([]*models.Message, error) {
+	rows, err := pool.Query(context.Background(), `select m.id, m.client_id,
+		case when m.deleted_at is null and h.hidden_at is null then m.body end, u.username, m.created_at,
+		m.edited_at, coalesce(m.deleted_at, h.hidden_at), cm.seq, `+ReactionCounts+`,
+		m.reply_to, `+QuotedParent+`,
+		case when m.deleted_at is null and h.hidden_at is null then `+AttachedFile+` end,
+		`+directReceiver("cm.chat_id", "m.user_messages")+` from chat_messages cm
+		inner join messages m on m.id = cm.message_id
+		left join users u on u.id = m.user_messages
+		left join hidden_messages h on h.message_id = m.id and h.user_id = $4
+		where cm.chat_id = $1 and cm.seq > $2
+		order by cm.seq limit $3`, chatID, after, limit, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]*models.Message, 0)
+	for rows.Next() {
+		message := &models.Message{ChatID: &chatID}
+		err := rows.Scan(&message.ID, &message.ClientID, &message.Body, &message.Sender, &message.CreatedAt,
+			&message.EditedAt, &message.DeletedAt, &message.Seq, &message.Reactions,
+			&message.ReplyTo, &message.Quote, &message.Attachment, &message.Receiver)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"testing"
+
+	"server/db/utils"
+	"server/models"
+	"server/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConnection(t *testing.T) *Connection {
+	files, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	username := "noname"
+	sm := NewSocketManager(NewLocalBroker(), files, DefaultConfig())
+	return NewConnection(&utils.User{Username: &username}, nil, sm, nil, NewSessionID(), "test")
+}
+
+func chatMessage(chatID int, seq int64) *models.Envelope {
+	return &models.Envelope{Type: models.TypeMessage, Message: &models.Message{ChatID: &chatID, Seq: &seq}}
+}
+
+func chatMessages(chatID int, seqs ...int64) []*models.Message {
+	messages := make([]*models.Message, 0, len(seqs))
+	for _, seq := range seqs {
+		messages = append(messages, chatMessage(chatID, seq).Message)
+	}
+	return messages
+}
+
+// sentSeqs returns the sequences of the messages queued for the client, in order
+func sentSeqs(t *testing.T, u *Connection) []int64 {
+	seqs := make([]int64, 0)
+	for {
+		select {
+		case b := <-u.send:
+			frame, err := u.Codec.Unmarshal(b)
+			require.NoError(t, err)
+			seqs = append(seqs, *frame.Message.Seq)
+		default:
+			return seqs
+		}
+	}
+}
+
+func TestResumeKeepsTheHoldPastItsTimer(t *testing.T) {
+	u := newTestConnection(t)
+	u.HoldLive()
+	u.Send(chatMessage(1, 5))
+
+	live := u.holdForResume()
+	// the timer of HoldLive going off while the replay runs
+	u.expireHold()
+	require.Empty(t, sentSeqs(t, u))
+
+	replayed := make(map[int]int64)
+	require.True(t, u.replay(1, chatMessages(1, 4, 5), live, replayed))
+	u.Send(chatMessage(1, 6))
+	u.release(replayed)
+
+	require.Equal(t, []int64{4, 5, 6}, sentSeqs(t, u))
+}
+
+func TestLateResumeSkipsMessagesSentLive(t *testing.T) {
+	u := newTestConnection(t)
+	u.HoldLive()
+	u.Send(chatMessage(1, 5))
+
+	// no resume came in time
+	u.expireHold()
+	u.Send(chatMessage(1, 6))
+	require.Equal(t, []int64{5, 6}, sentSeqs(t, u))
+
+	live := u.holdForResume()
+	u.Send(chatMessage(1, 7))
+	replayed := make(map[int]int64)
+	require.True(t, u.replay(1, chatMessages(1, 3, 4, 5, 6, 7), live, replayed))
+	require.True(t, u.replay(2, chatMessages(2, 1), live, replayed))
+	u.release(replayed)
+
+	require.Equal(t, []int64{3, 4, 1, 7}, sentSeqs(t, u))
+}
@@ -2,85 +2,325 @@ package core
 
 import (
 	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"server/models"
+	"server/storage"
+
+	"github.com/gorilla/websocket"
 )
 
 /*
-The socket manager stores all user connections. Each incoming message
-will be forwarded by the socket manager to the corresponding user.
+Delivery is a message addressed to a set of users. A delivery without
+recipients is sent to every connected user. Recipients who blocked the
+sender, when there is one, are left out.
+*/
+type Delivery struct {
+	Recipients []string         `json:"recipients"`
+	Sender     string           `json:"sender,omitempty"`
+	Frame      *models.Envelope `json:"frame"`
+}
+
+// deliveries waiting to be published to other instances
+const publishQueue = 256
+
+/*
+The socket manager stores all user connections. A user can be connected from
+several devices at once so connections are grouped by username and then by
+session id. Each incoming message will be forwarded by the socket manager to
+every session of the corresponding user. Deliveries are also handed to the
+broker so users connected to other instances get them too, from a goroutine
+of their own so a slow broker never holds up the hub.
 */
 type SocketManager struct {
-	Messages    chan *models.Message
+	Messages    chan *Delivery
 	Join        chan *Connection
 	Leave       chan *Connection
+	Remote      chan *Delivery
 	Id          int
-	Connections map[string]*Connection
+	Connections map[string]map[string]*Connection
+	// guards Connections for readers outside of Run
+	mu sync.RWMutex
+	// orders how sessions are shared with the broker
+	tracking sync.Mutex
+	broker   Broker
+	// keeps attachment files, a retracted message takes its file along
+	storage storage.Storage
+	// deliveries for the broker, a full queue drops them
+	published chan *Delivery
+	config    Config
+	// frames dropped and sessions evicted because of slow clients
+	dropped     atomic.Uint64
+	evicted     atomic.Uint64
+	unpublished atomic.Uint64
+	members     *memberCache
 }
 
-func NewSocketManager() *SocketManager {
+func NewSocketManager(broker Broker, storage storage.Storage, config Config) *SocketManager {
 	return &SocketManager{
-		Messages:    make(chan *models.Message),
+		Messages:    make(chan *Delivery),
 		Join:        make(chan *Connection),
 		Leave:       make(chan *Connection),
-		Connections: make(map[string]*Connection),
+		Remote:      make(chan *Delivery),
+		Connections: make(map[string]map[string]*Connection),
+		broker:      broker,
+		storage:     storage,
+		published:   make(chan *Delivery, publishQueue),
+		config:      config,
+		members:     newMemberCache(),
 	}
 }
 
+// PingInterval is how often connections are pinged, clients are told in the handshake
+func (sm *SocketManager) PingInterval() time.Duration {
+	return sm.config.PingInterval
+}
+
+// ForgetMembers drops the cached members of a chat after they changed
+func (sm *SocketManager) ForgetMembers(chatID int) {
+	sm.members.forget(chatID)
+}
+
 func (c *SocketManager) Run() {
 	fmt.Println("running chat ... ")
+	err := c.broker.Subscribe(func(delivery *Delivery) {
+		c.Remote <- delivery
+	})
+	if err != nil {
+		log.Println("Error subscribing to broker:", err.Error())
+	}
+	go c.publish()
+
 	for {
 		select {
 		case user := <-c.Join:
 			c.add(user)
 		case message := <-c.Messages:
 			c.broadcast(message)
+		case message := <-c.Remote:
+			c.deliver(message)
 		case user := <-c.Leave:
 			c.disconnect(user)
 		}
 	}
 }
 func (sm *SocketManager) add(con *Connection) {
-	if _, ok := sm.Connections[*con.User.Username]; !ok {
-		sm.Connections[*con.User.Username] = con
+	sm.mu.Lock()
+	sessions, online := sm.Connections[*con.User.Username]
+	if !online {
+		sessions = make(map[string]*Connection)
+		sm.Connections[*con.User.Username] = sessions
+	}
+	sessions[con.SessionID] = con
+	sm.mu.Unlock()
+
+	log.Printf("%s joined from %s [session %s]", *con.User.Username, con.Device, con.SessionID)
+
+	// presence only changes with the first session
+	go sm.track(con, !online)
+}
+
+/*
+track shares a session that joined or left with the other instances. Whether
+it is live is read when track runs, so of a join and a leave racing the last
+one wins. When it was the first or the last session of its user on this
+instance, their presence is announced too.
+*/
+func (sm *SocketManager) track(con *Connection, announce bool) {
+	sm.tracking.Lock()
+	var err error
+	if sm.holds(con) {
+		err = sm.broker.SessionJoined(*con.User.Username, con.Session())
+	} else {
+		err = sm.broker.SessionLeft(con.SessionID)
+	}
+	sm.tracking.Unlock()
+	if err != nil {
+		log.Printf("Session %s of %s not shared: %v", con.SessionID, *con.User.Username, err)
+	}
+
+	if announce {
+		sm.announce(con, sm.holds(con))
+	}
+}
+
+// holds reports whether the connection is a live session of this instance
+func (sm *SocketManager) holds(con *Connection) bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.Connections[*con.User.Username][con.SessionID] == con
+}
+
+// broadcast delivers to the local sessions and queues the delivery for other instances
+func (sm *SocketManager) broadcast(delivery *Delivery) {
+	sm.deliver(delivery)
 
-		body := fmt.Sprintf("%s is online", *con.User.Username)
-		sender := con.User.Username
-		sm.broadcast(&models.Message{
-			Body:   &body,
-			Sender: sender,
-		})
+	select {
+	case sm.published <- delivery:
+	default:
+		sm.unpublished.Add(1)
+		// clients on other instances catch up when they resume
+		log.Printf("Publish queue is full, delivery %s not published", delivery.Frame.Type)
 	}
 }
 
-func (sm *SocketManager) broadcast(message *models.Message) {
-	if message.Receiver == nil {
-		// offline and online notification to all user
-		for _, user := range sm.Connections {
-			user.Send(message)
+// publish hands the queued deliveries to the broker one at a time
+func (sm *SocketManager) publish() {
+	for delivery := range sm.published {
+		if err := sm.broker.Publish(delivery); err != nil {
+			log.Println("Error publishing delivery:", err.Error())
 		}
-		return
 	}
+}
+
+// deliver sends the message to the recipients connected to this instance
+func (sm *SocketManager) deliver(delivery *Delivery) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
 
-	if user, ok := sm.Connections[*message.Sender]; ok {
-		user.Send(message)
+	if delivery.Recipients == nil {
+		for _, sessions := range sm.Connections {
+			for _, user := range sessions {
+				user.Send(delivery.Frame)
+			}
+		}
+		return
 	}
 
-	if user, ok := sm.Connections[*message.Receiver]; ok {
-		user.Send(message)
+	for _, username := range delivery.Recipients {
+		for _, user := range sm.Connections[username] {
+			if delivery.Frame.Type == models.TypeBlock {
+				user.setBlocked(delivery.Frame.Block)
+			}
+			if delivery.Sender != "" && user.blocks(delivery.Sender) {
+				continue
+			}
+			user.Send(delivery.Frame)
+		}
 	}
 }
 
 func (sm *SocketManager) disconnect(con *Connection) {
-	if _, ok := sm.Connections[*con.User.Username]; ok {
-		defer con.Conn.Close()
+	sm.mu.Lock()
+	sessions, ok := sm.Connections[*con.User.Username]
+	if ok {
+		if _, ok = sessions[con.SessionID]; ok {
+			delete(sessions, con.SessionID)
+		}
+	}
+	offline := ok && len(sessions) == 0
+	if offline {
 		delete(sm.Connections, *con.User.Username)
+	}
+	sm.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	defer con.Conn.Close()
+
+	log.Printf("%s left from %s [session %s]", *con.User.Username, con.Device, con.SessionID)
+
+	// presence only changes when the last session leaves
+	go sm.track(con, offline)
+}
+
+/*
+Online returns which of the usernames have a session on any instance. Other
+instances are asked through the broker, when it fails only this one counts.
+*/
+func (sm *SocketManager) Online(usernames []string) map[string]bool {
+	online := make(map[string]bool, len(usernames))
+	elsewhere := make([]string, 0, len(usernames))
+	sm.mu.RLock()
+	for _, username := range usernames {
+		if len(sm.Connections[username]) > 0 {
+			online[username] = true
+		} else {
+			elsewhere = append(elsewhere, username)
+		}
+	}
+	sm.mu.RUnlock()
+
+	if len(elsewhere) == 0 {
+		return online
+	}
+	remote, err := sm.broker.RemoteSessions(elsewhere)
+	if err != nil {
+		log.Println("Error loading sessions of other instances:", err.Error())
+		return online
+	}
+	for username, sessions := range remote {
+		if len(sessions) > 0 {
+			online[username] = true
+		}
+	}
+	return online
+}
+
+// Sessions lists the live sessions of the user on every instance ordered by connection time
+func (sm *SocketManager) Sessions(username string) []models.Session {
+	remote, err := sm.broker.RemoteSessions([]string{username})
+	if err != nil {
+		log.Println("Error loading sessions of other instances:", err.Error())
+	}
+
+	sm.mu.RLock()
+	sessions := make([]models.Session, 0, len(sm.Connections[username])+len(remote[username]))
+	for _, con := range sm.Connections[username] {
+		sessions = append(sessions, con.Session())
+	}
+	sm.mu.RUnlock()
+
+	sessions = append(sessions, remote[username]...)
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+	})
+
+	return sessions
+}
+
+func (sm *SocketManager) Stats() models.HubStats {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	stats := models.HubStats{
+		Users:         len(sm.Connections),
+		DroppedFrames: sm.dropped.Load(),
+		Evicted:       sm.evicted.Load(),
+		Unpublished:   sm.unpublished.Load(),
+	}
+	for _, sessions := range sm.Connections {
+		stats.Sessions += len(sessions)
+	}
+
+	return stats
+}
+
+/*
+Shutdown tells every client the server is going away and closes their
+sockets, each Listen then returns and its session leaves.
+*/
+func (sm *SocketManager) Shutdown() {
+	sm.mu.RLock()
+	var wg sync.WaitGroup
+	for _, sessions := range sm.Connections {
+		for _, con := range sessions {
+			wg.Add(1)
+			go func(con *Connection) {
+				defer wg.Done()
+				con.Close(websocket.CloseGoingAway, "server shutting down")
+			}(con)
+		}
+	}
+	sm.mu.RUnlock()
+	wg.Wait()
 
-		body := fmt.Sprintf("%s is offline", *con.User.Username)
-		sender := con.User.Username
-		sm.broadcast(&models.Message{
-			Body:   &body,
-			Sender: sender,
-		})
+	if err := sm.broker.Close(); err != nil {
+		log.Println("Error closing broker:", err.Error())
 	}
 }
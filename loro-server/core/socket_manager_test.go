@@ -0,0 +1,73 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"server/core"
+	"server/models"
+	"server/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newStorage(t *testing.T) storage.Storage {
+	files, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	return files
+}
+
+// stuckBroker never returns from Publish, like a database that stopped answering
+type stuckBroker struct {
+	core.LocalBroker
+	stuck chan struct{}
+}
+
+func (b *stuckBroker) Publish(delivery *core.Delivery) error {
+	<-b.stuck
+	return nil
+}
+
+func TestSlowBrokerDoesNotHoldUpTheHub(t *testing.T) {
+	broker := &stuckBroker{stuck: make(chan struct{})}
+	defer close(broker.stuck)
+	sm := core.NewSocketManager(broker, newStorage(t), core.DefaultConfig())
+	go sm.Run()
+
+	frame := &models.Envelope{Type: models.TypeTyping, Typing: &models.Typing{ChatID: 1, State: models.TypingStart}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// more deliveries than the publish queue holds
+		for i := 0; i < 1000; i++ {
+			sm.Messages <- &core.Delivery{Recipients: []string{"nobody"}, Frame: frame}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hub waited on the broker")
+	}
+	require.NotZero(t, sm.Stats().Unpublished)
+}
+
+// remoteBroker reports sessions held by another instance
+type remoteBroker struct {
+	core.LocalBroker
+	sessions map[string][]models.Session
+}
+
+func (b *remoteBroker) RemoteSessions(usernames []string) (map[string][]models.Session, error) {
+	return b.sessions, nil
+}
+
+func TestUsersOnAnotherInstanceAreOnline(t *testing.T) {
+	session := models.Session{ID: "remote", Device: "phone", ConnectedAt: time.Now()}
+	sm := core.NewSocketManager(&remoteBroker{sessions: map[string][]models.Session{"ana": {session}}},
+		newStorage(t), core.DefaultConfig())
+
+	require.Equal(t, map[string]bool{"ana": true}, sm.Online([]string{"ana", "bob"}))
+	require.Equal(t, []models.Session{session}, sm.Sessions("ana"))
+	require.Empty(t, sm.Sessions("bob"))
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"context"
+	"log"
+
+	"server/models"
+)
+
+/*
+UnreadCount is a column with how many messages of others the member me has
+not read in its chat, counted from the sequence of its read cursor. Deleted
+messages and the ones the member hid are left out.
+*/
+const UnreadCount = `(select count(*) from chat_messages uc inner join messages m on m.id = uc.message_id
+	where uc.chat_id = me.chat_id and m.user_messages is distinct from me.user_id and m.deleted_at is null
+	and uc.seq > coalesce((select seq from chat_messages where chat_id = me.chat_id and message_id = me.last_read_message_id), 0)
+	and not exists(select 1 from hidden_messages h where h.message_id = m.id and h.user_id = me.user_id))`
+
+// others returns the usernames without the given one
+func others(usernames []string, username string) []string {
+	rest := make([]string, 0, len(usernames))
+	for _, name := range usernames {
+		if name != username {
+			rest = append(rest, name)
+		}
+	}
+	return rest
+}
+
+/*
+sendUnread sends the members among the usernames their new unread count in
+the chat. The counts only tell clients sooner, a failure is logged and the
+next chat list has them right again.
+*/
+func sendUnread(q querier, sm *SocketManager, chatID int, usernames []string) {
+	if len(usernames) == 0 {
+		return
+	}
+	rows, err := q.Query(context.Background(), `select u.username, `+UnreadCount+` from chat_members me
+		inner join users u on u.id = me.user_id where me.chat_id = $1 and u.username = any($2)`, chatID, usernames)
+	if err != nil {
+		log.Printf("Unread counts of chat %d not sent: %v", chatID, err)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := make([]*Delivery, 0, len(usernames))
+	for rows.Next() {
+		var username string
+		unread := &models.Unread{ChatID: chatID}
+		if err := rows.Scan(&username, &unread.Count); err != nil {
+			log.Printf("Unread counts of chat %d not sent: %v", chatID, err)
+			return
+		}
+		deliveries = append(deliveries, &Delivery{
+			Recipients: []string{username},
+			Frame:      &models.Envelope{Type: models.TypeUnread, Unread: unread},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Unread counts of chat %d not sent: %v", chatID, err)
+		return
+	}
+	rows.Close()
+
+	for _, delivery := range deliveries {
+		sm.Messages <- delivery
+	}
+}
@@ -80,3 +80,40 @@ func (r *PostgresPool) Transaction(ctx context.Context, fn func(pgx.Tx) error) e
 
 	return tx.Commit(ctx)
 }
+
+// Notify sends the payload to every connection listening on the channel
+func (r *PostgresPool) Notify(ctx context.Context, channel, payload string) error {
+	_, err := r.pool.Exec(ctx, `select pg_notify($1, $2)`, channel, payload)
+	return err
+}
+
+/*
+Listen holds a connection of the pool subscribed to the channel and calls
+handler with the payload of every notification until ctx is cancelled or the
+connection fails.
+*/
+func (r *PostgresPool) Listen(ctx context.Context, channel string, handler func(payload string)) error {
+	conn, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if !conn.Conn().IsClosed() {
+			// do not give back a subscribed connection to the pool
+			_, _ = conn.Exec(context.Background(), `unlisten *`)
+		}
+		conn.Release()
+	}()
+
+	if _, err := conn.Exec(ctx, "listen "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		handler(notification.Payload)
+	}
+}
@@ -3,10 +3,24 @@ package utils
 import "time"
 
 type Message struct {
-	ID        *uint      `json:"id"`
-	Body      *string    `json:"body"`
-	CreatedAt *time.Time `json:"created_at"`
-	Sender    *string    `json:"sender"`
+	ID         *uint       `json:"id"`
+	Body       *string     `json:"body"`
+	CreatedAt  *time.Time  `json:"created_at"`
+	Sender     *string     `json:"sender"`
+	Seq        *int64      `json:"seq"`
+	EditedAt   *time.Time  `json:"edited_at,omitempty"`
+	DeletedAt  *time.Time  `json:"deleted_at,omitempty"`
+	Reactions  []Reaction  `json:"reactions,omitempty"`
+	ReplyTo    *uint       `json:"replyTo,omitempty"`
+	Quote      *Quote      `json:"quote,omitempty"`
+	Attachment *Attachment `json:"attachment,omitempty"`
+}
+
+type Attachment struct {
+	ID          *uint   `json:"id"`
+	Filename    *string `json:"filename,omitempty"`
+	ContentType *string `json:"contentType,omitempty"`
+	Size        *int64  `json:"size,omitempty"`
 }
 type User struct {
 	ID         *uint      `json:"id"`
@@ -15,11 +29,83 @@ type User struct {
 	Password   *string    `json:"password"`
 	PrivateKey []byte     `json:"private_key"`
 	PublicKey  []byte     `json:"public_key"`
+	Role       *string    `json:"role,omitempty"`
 }
 
 type Chat struct {
 	RecipientUsername *string    `json:"username"`
 	ID                *uint      `json:"id"`
+	Type              *string    `json:"type"`
+	Name              *string    `json:"name,omitempty"`
 	LastMessage       *string    `json:"last_message"`
 	LastMessageTime   *time.Time `json:"last_message_time"`
+	LastSeq           *int64     `json:"last_seq"`
+	Unread            int        `json:"unread"`
+	Archived          bool       `json:"archived"`
+	Hidden            bool       `json:"hidden"`
+	MutedUntil        *time.Time `json:"muted_until,omitempty"`
+}
+
+type Quote struct {
+	ID      *uint   `json:"id"`
+	Sender  *string `json:"sender,omitempty"`
+	Body    *string `json:"body,omitempty"`
+	Deleted bool    `json:"deleted,omitempty"`
+}
+
+type Reaction struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+type MessagePage struct {
+	Messages []Message `json:"messages"`
+	// absent on the last page
+	NextCursor *string `json:"next_cursor,omitempty"`
+}
+
+type SearchHit struct {
+	MessageID *uint      `json:"message_id"`
+	ChatID    *uint      `json:"chat_id"`
+	Seq       *int64     `json:"seq"`
+	Sender    *string    `json:"sender"`
+	CreatedAt *time.Time `json:"created_at"`
+	Snippet   *string    `json:"snippet"`
+}
+
+type SearchPage struct {
+	Hits []SearchHit `json:"hits"`
+	// absent on the last page
+	NextCursor *string `json:"next_cursor,omitempty"`
+}
+
+type Revision struct {
+	Body      *string    `json:"body"`
+	WrittenAt *time.Time `json:"written_at"`
+}
+
+type ReadCursor struct {
+	Username  *string    `json:"username"`
+	MessageID *int       `json:"message_id"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+// Contact is a contact of the user, shown by the nickname the user gave them if any
+type Contact struct {
+	Username   string     `json:"username"`
+	Nickname   *string    `json:"nickname,omitempty"`
+	Online     bool       `json:"online"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	Since      time.Time  `json:"since"`
+}
+
+type ContactRequest struct {
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ContactRequests are the requests sent to the user and the ones they sent, the latest first
+type ContactRequests struct {
+	Incoming []ContactRequest `json:"incoming"`
+	Outgoing []ContactRequest `json:"outgoing"`
 }
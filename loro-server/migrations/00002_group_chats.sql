@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE public.chats ADD COLUMN "name" varchar NULL;
+ALTER TABLE public.chats ADD COLUMN created_at timestamptz DEFAULT now() NOT NULL;
+
+-- two-person chats were stored as 'public' before groups existed
+UPDATE public.chats SET "type" = 'direct' WHERE "type" = 'public';
+
+ALTER TABLE public.chat_members ADD COLUMN "role" varchar DEFAULT 'member' NOT NULL;
+ALTER TABLE public.chat_members ADD COLUMN joined_at timestamptz DEFAULT now() NOT NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE public.chat_members DROP COLUMN joined_at;
+ALTER TABLE public.chat_members DROP COLUMN "role";
+
+UPDATE public.chats SET "type" = 'public' WHERE "type" = 'direct';
+
+ALTER TABLE public.chats DROP COLUMN created_at;
+ALTER TABLE public.chats DROP COLUMN "name";
+
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- id chosen by the client so retries of the same message are stored once
+ALTER TABLE public.messages ADD COLUMN client_id varchar NULL;
+CREATE UNIQUE INDEX messages_client_id_key ON public.messages USING btree (user_messages, client_id) WHERE client_id IS NOT NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX public.messages_client_id_key;
+ALTER TABLE public.messages DROP COLUMN client_id;
+
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- last message each member has seen in the chat
+ALTER TABLE public.chat_members ADD COLUMN last_read_message_id int8 NULL;
+ALTER TABLE public.chat_members ADD COLUMN last_read_at timestamptz NULL;
+ALTER TABLE public.chat_members ADD CONSTRAINT chat_members_last_read_message_id FOREIGN KEY (last_read_message_id) REFERENCES public.messages(id) ON DELETE SET NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE public.chat_members DROP CONSTRAINT chat_members_last_read_message_id;
+ALTER TABLE public.chat_members DROP COLUMN last_read_at;
+ALTER TABLE public.chat_members DROP COLUMN last_read_message_id;
+
+-- +goose StatementEnd
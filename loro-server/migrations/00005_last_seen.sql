@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE public.users ADD COLUMN last_seen_at timestamptz NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE public.users DROP COLUMN last_seen_at;
+
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE public.chats ADD COLUMN last_seq int8 DEFAULT 0 NOT NULL;
+ALTER TABLE public.chat_messages ADD COLUMN seq int8 NULL;
+
+-- number the messages already stored in every chat in the order they were sent
+UPDATE public.chat_messages cm SET seq = numbered.seq FROM (
+	SELECT chat_id, message_id, row_number() OVER (PARTITION BY chat_id ORDER BY message_id) AS seq
+	FROM public.chat_messages
+) numbered WHERE cm.chat_id = numbered.chat_id AND cm.message_id = numbered.message_id;
+UPDATE public.chats c SET last_seq = coalesce((SELECT max(seq) FROM public.chat_messages WHERE chat_id = c.id), 0);
+
+ALTER TABLE public.chat_messages ALTER COLUMN seq SET NOT NULL;
+CREATE UNIQUE INDEX chat_messages_chat_id_seq_key ON public.chat_messages USING btree (chat_id, seq);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX public.chat_messages_chat_id_seq_key;
+ALTER TABLE public.chat_messages DROP COLUMN seq;
+ALTER TABLE public.chats DROP COLUMN last_seq;
+
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE public.messages ADD COLUMN edited_at timestamptz NULL;
+
+-- every body a message had before it was edited
+CREATE TABLE public.message_revisions (
+	id int8 GENERATED BY DEFAULT AS IDENTITY( INCREMENT BY 1 MINVALUE 1 MAXVALUE 9223372036854775807 START 1 CACHE 1 NO CYCLE) NOT NULL,
+	message_id int8 NOT NULL,
+	body varchar NOT NULL,
+	-- when this body was written, the message creation or a previous edit
+	written_at timestamptz NOT NULL,
+	CONSTRAINT message_revisions_pkey PRIMARY KEY (id),
+	CONSTRAINT message_revisions_message_id FOREIGN KEY (message_id) REFERENCES public.messages(id) ON DELETE CASCADE
+);
+CREATE INDEX message_revisions_message_id_idx ON public.message_revisions USING btree (message_id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE public.message_revisions;
+ALTER TABLE public.messages DROP COLUMN edited_at;
+
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- a message deleted for everyone stays as a tombstone without body
+ALTER TABLE public.messages ADD COLUMN deleted_at timestamptz NULL;
+
+-- messages a user deleted only for themselves
+CREATE TABLE public.hidden_messages (
+	user_id int8 NOT NULL,
+	message_id int8 NOT NULL,
+	hidden_at timestamptz DEFAULT now() NOT NULL,
+	CONSTRAINT hidden_messages_pkey PRIMARY KEY (user_id, message_id),
+	CONSTRAINT hidden_messages_user_id FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE,
+	CONSTRAINT hidden_messages_message_id FOREIGN KEY (message_id) REFERENCES public.messages(id) ON DELETE CASCADE
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE public.hidden_messages;
+ALTER TABLE public.messages DROP COLUMN deleted_at;
+
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- a user has at most one reaction on a message
+CREATE TABLE public.message_reactions (
+	message_id int8 NOT NULL,
+	user_id int8 NOT NULL,
+	emoji text NOT NULL,
+	created_at timestamptz DEFAULT now() NOT NULL,
+	CONSTRAINT message_reactions_pkey PRIMARY KEY (message_id, user_id),
+	CONSTRAINT message_reactions_message_id FOREIGN KEY (message_id) REFERENCES public.messages(id) ON DELETE CASCADE,
+	CONSTRAINT message_reactions_user_id FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE public.message_reactions;
+
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- the message a reply answers, replies stay when it is removed
+ALTER TABLE public.messages ADD COLUMN reply_to int8 NULL;
+ALTER TABLE public.messages ADD CONSTRAINT messages_reply_to FOREIGN KEY (reply_to) REFERENCES public.messages(id) ON DELETE SET NULL;
+CREATE INDEX messages_reply_to_idx ON public.messages USING btree (reply_to);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE public.messages DROP COLUMN reply_to;
+
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- members named in a group message, read once their read cursor passes it
+CREATE TABLE public.message_mentions (
+	message_id int8 NOT NULL,
+	user_id int8 NOT NULL,
+	read_at timestamptz NULL,
+	CONSTRAINT message_mentions_pkey PRIMARY KEY (message_id, user_id),
+	CONSTRAINT message_mentions_message_id FOREIGN KEY (message_id) REFERENCES public.messages(id) ON DELETE CASCADE,
+	CONSTRAINT message_mentions_user_id FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+CREATE INDEX message_mentions_unread_idx ON public.message_mentions USING btree (user_id) WHERE read_at IS NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE public.message_mentions;
+
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE public.pinned_messages (
+	chat_id int8 NOT NULL,
+	message_id int8 NOT NULL,
+	pinned_by int8 NULL,
+	pinned_at timestamptz DEFAULT now() NOT NULL,
+	CONSTRAINT pinned_messages_pkey PRIMARY KEY (chat_id, message_id),
+	CONSTRAINT pinned_messages_chat_id FOREIGN KEY (chat_id) REFERENCES public.chats(id) ON DELETE CASCADE,
+	CONSTRAINT pinned_messages_message_id FOREIGN KEY (message_id) REFERENCES public.messages(id) ON DELETE CASCADE,
+	CONSTRAINT pinned_messages_pinned_by FOREIGN KEY (pinned_by) REFERENCES public.users(id) ON DELETE SET NULL
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE public.pinned_messages;
+
+-- +goose StatementEnd
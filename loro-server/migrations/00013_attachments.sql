@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- files uploaded to a chat, linked to a message once it is sent
+CREATE TABLE public.attachments (
+	id int8 GENERATED BY DEFAULT AS IDENTITY( INCREMENT BY 1 MINVALUE 1 MAXVALUE 9223372036854775807 START 1 CACHE 1 NO CYCLE) NOT NULL,
+	chat_id int8 NOT NULL,
+	uploader_id int8 NULL,
+	message_id int8 NULL,
+	storage_key text NOT NULL,
+	filename text NOT NULL,
+	content_type text NOT NULL,
+	size int8 NOT NULL,
+	created_at timestamptz DEFAULT now() NOT NULL,
+	CONSTRAINT attachments_pkey PRIMARY KEY (id),
+	CONSTRAINT attachments_storage_key_key UNIQUE (storage_key),
+	CONSTRAINT attachments_chat_id FOREIGN KEY (chat_id) REFERENCES public.chats(id) ON DELETE CASCADE,
+	CONSTRAINT attachments_uploader_id FOREIGN KEY (uploader_id) REFERENCES public.users(id) ON DELETE SET NULL,
+	CONSTRAINT attachments_message_id FOREIGN KEY (message_id) REFERENCES public.messages(id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX attachments_message_id_idx ON public.attachments USING btree (message_id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE public.attachments;
+
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- words of the body for full-text search, 'simple' so no language is assumed
+ALTER TABLE public.messages ADD COLUMN body_tsv tsvector
+	GENERATED ALWAYS AS (to_tsvector('simple', body)) STORED;
+CREATE INDEX messages_body_tsv_idx ON public.messages USING gin (body_tsv);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE public.messages DROP COLUMN body_tsv;
+
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- how each member files the chat, the other members are not affected
+ALTER TABLE public.chat_members ADD COLUMN archived bool DEFAULT false NOT NULL;
+ALTER TABLE public.chat_members ADD COLUMN hidden bool DEFAULT false NOT NULL;
+ALTER TABLE public.chat_members ADD COLUMN muted_until timestamptz NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE public.chat_members DROP COLUMN muted_until;
+ALTER TABLE public.chat_members DROP COLUMN hidden;
+ALTER TABLE public.chat_members DROP COLUMN archived;
+
+-- +goose StatementEnd
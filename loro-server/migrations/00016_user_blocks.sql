@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- blocked users cannot open or write to a direct chat with the blocker, nor see them online
+CREATE TABLE public.user_blocks (
+	blocker_id int8 NOT NULL,
+	blocked_id int8 NOT NULL,
+	created_at timestamptz DEFAULT now() NOT NULL,
+	CONSTRAINT user_blocks_pkey PRIMARY KEY (blocker_id, blocked_id),
+	CONSTRAINT user_blocks_blocker_id FOREIGN KEY (blocker_id) REFERENCES public.users(id) ON DELETE CASCADE,
+	CONSTRAINT user_blocks_blocked_id FOREIGN KEY (blocked_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+CREATE INDEX user_blocks_blocked_id_idx ON public.user_blocks USING btree (blocked_id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE public.user_blocks;
+
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- a request waits here until the user it was sent to accepts or declines it
+CREATE TABLE public.contact_requests (
+	from_id int8 NOT NULL,
+	to_id int8 NOT NULL,
+	created_at timestamptz DEFAULT now() NOT NULL,
+	CONSTRAINT contact_requests_pkey PRIMARY KEY (from_id, to_id),
+	CONSTRAINT contact_requests_from_id FOREIGN KEY (from_id) REFERENCES public.users(id) ON DELETE CASCADE,
+	CONSTRAINT contact_requests_to_id FOREIGN KEY (to_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+CREATE INDEX contact_requests_to_id_idx ON public.contact_requests USING btree (to_id);
+
+-- accepted contacts are stored once for each side, with the nickname that side gave the other
+CREATE TABLE public.contacts (
+	user_id int8 NOT NULL,
+	contact_id int8 NOT NULL,
+	nickname varchar NULL,
+	created_at timestamptz DEFAULT now() NOT NULL,
+	CONSTRAINT contacts_pkey PRIMARY KEY (user_id, contact_id),
+	CONSTRAINT contacts_user_id FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE,
+	CONSTRAINT contacts_contact_id FOREIGN KEY (contact_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE public.contacts;
+DROP TABLE public.contact_requests;
+
+-- +goose StatementEnd
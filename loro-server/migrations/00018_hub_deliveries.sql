@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- deliveries too large for a notification, instances are notified of the id and read them here
+CREATE TABLE public.hub_deliveries (
+	id int8 GENERATED BY DEFAULT AS IDENTITY( INCREMENT BY 1 MINVALUE 1 MAXVALUE 9223372036854775807 START 1 CACHE 1 NO CYCLE) NOT NULL,
+	payload text NOT NULL,
+	created_at timestamptz DEFAULT now() NOT NULL,
+	CONSTRAINT hub_deliveries_pkey PRIMARY KEY (id)
+);
+CREATE INDEX hub_deliveries_created_at_idx ON public.hub_deliveries USING btree (created_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE public.hub_deliveries;
+
+-- +goose StatementEnd
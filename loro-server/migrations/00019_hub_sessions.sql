@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- live sessions of every server instance, refreshed by their instance while it runs
+CREATE TABLE public.hub_sessions (
+	session_id text NOT NULL,
+	instance text NOT NULL,
+	username varchar NOT NULL,
+	device text NOT NULL,
+	connected_at timestamptz NOT NULL,
+	seen_at timestamptz DEFAULT now() NOT NULL,
+	CONSTRAINT hub_sessions_pkey PRIMARY KEY (session_id)
+);
+CREATE INDEX hub_sessions_username_idx ON public.hub_sessions USING btree (username);
+CREATE INDEX hub_sessions_instance_idx ON public.hub_sessions USING btree (instance);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE public.hub_sessions;
+
+-- +goose StatementEnd
@@ -0,0 +1,77 @@
+package models
+
+import "time"
+
+type GroupRequest struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+type MemberRequest struct {
+	Username string `json:"username"`
+}
+
+type EditRequest struct {
+	Body string `json:"body"`
+}
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+type PinRequest struct {
+	MessageID int `json:"message_id"`
+}
+
+// MessagesQuery picks a page of messages, by cursor or by sequence
+type MessagesQuery struct {
+	Limit  int
+	Cursor string
+	Before *int64
+	After  *int64
+}
+
+// SearchQuery narrows a search, filters left empty do not apply
+type SearchQuery struct {
+	Q      string
+	ChatID *int
+	Sender *string
+	// created from this moment on and before To
+	From   *time.Time
+	To     *time.Time
+	Cursor string
+	Limit  int
+}
+
+// ChatState is how a member files a chat, muted until the time if set
+type ChatState struct {
+	Archived   bool       `json:"archived"`
+	Hidden     bool       `json:"hidden"`
+	MutedUntil *time.Time `json:"muted_until"`
+}
+
+/*
+ChatStateUpdate changes how a member files a chat, fields left out stay as
+they are. A muted_until that already passed unmutes.
+*/
+type ChatStateUpdate struct {
+	Archived   *bool      `json:"archived,omitempty"`
+	Hidden     *bool      `json:"hidden,omitempty"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+}
+
+// Chat list filters, the inbox leaves archived and hidden chats out
+const (
+	ChatsInbox    = "inbox"
+	ChatsArchived = "archived"
+	ChatsHidden   = "hidden"
+	ChatsAll      = "all"
+)
+
+type ReadRequest struct {
+	MessageID int `json:"message_id"`
+}
+
+type NicknameRequest struct {
+	Nickname string `json:"nickname"`
+}
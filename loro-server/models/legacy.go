@@ -0,0 +1,80 @@
+package models
+
+import "time"
+
+// States of a version 0 presence frame
+const (
+	legacyOnline  = "online"
+	legacyOffline = "offline"
+)
+
+/*
+LegacyFrame is the flat frame of protocol version 0, spoken by clients that
+join without asking for a version. Every kind of frame is a message with a
+type, and one without type is a chat message.
+*/
+type LegacyFrame struct {
+	Type      string      `json:"type,omitempty"`
+	ID        *int        `json:"id,omitempty"`
+	ClientID  *string     `json:"clientId,omitempty"`
+	Body      *string     `json:"body,omitempty"`
+	Sender    *string     `json:"sender,omitempty"`
+	Receiver  *string     `json:"receiver,omitempty"`
+	ChatID    *int        `json:"chatId,omitempty"`
+	Seq       *int64      `json:"seq,omitempty"`
+	CreatedAt *time.Time  `json:"created_at,omitempty"`
+	State     string      `json:"state,omitempty"`
+	Cursors   []SeqCursor `json:"cursors,omitempty"`
+}
+
+// Envelope converts a frame sent by a version 0 client, nil when it has no counterpart
+func (f *LegacyFrame) Envelope() *Envelope {
+	switch f.Type {
+	case "", TypeMessage:
+		return &Envelope{Type: TypeMessage, Message: &Message{
+			ID:        f.ID,
+			ClientID:  f.ClientID,
+			ChatID:    f.ChatID,
+			Sender:    f.Sender,
+			Receiver:  f.Receiver,
+			Body:      f.Body,
+			CreatedAt: f.CreatedAt,
+		}}
+	case TypeRead:
+		if f.ChatID == nil || f.ID == nil {
+			return &Envelope{Type: TypeRead}
+		}
+		return &Envelope{Type: TypeRead, Read: &Read{ChatID: *f.ChatID, MessageID: *f.ID}}
+	case TypeTyping:
+		if f.ChatID == nil {
+			return &Envelope{Type: TypeTyping}
+		}
+		return &Envelope{Type: TypeTyping, Typing: &Typing{ChatID: *f.ChatID, State: f.State}}
+	case TypeResume:
+		return &Envelope{Type: TypeResume, Resume: &Resume{Cursors: f.Cursors}}
+	}
+	return nil
+}
+
+/*
+NewLegacyFrame converts a frame for a version 0 client, nil when it has no
+counterpart. Those clients take any frame with a chat for a chat message, so
+only messages and presence reach them, and they only know direct chats, so
+messages without receiver are left out.
+*/
+func NewLegacyFrame(e *Envelope) *LegacyFrame {
+	switch {
+	case e.Message != nil && e.Message.Receiver != nil:
+		m := e.Message
+		return &LegacyFrame{Type: TypeMessage, ID: m.ID, ClientID: m.ClientID, Body: m.Body, Sender: m.Sender,
+			Receiver: m.Receiver, ChatID: m.ChatID, Seq: m.Seq, CreatedAt: m.CreatedAt}
+	case e.Presence != nil:
+		state := legacyOffline
+		if e.Presence.Online {
+			state = legacyOnline
+		}
+		return &LegacyFrame{Type: TypePresence, Sender: &e.Presence.Username, State: state,
+			CreatedAt: e.Presence.LastSeenAt}
+	}
+	return nil
+}
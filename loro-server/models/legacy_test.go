@@ -0,0 +1,109 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"server/models"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLegacyFrame(t *testing.T) {
+	id, chatID, seq := 7, 3, int64(12)
+	body, sender, receiver := "hello", "ana", "bob"
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name  string
+		frame *models.Envelope
+		want  *models.LegacyFrame
+	}{
+		{
+			name: "message",
+			frame: &models.Envelope{Type: models.TypeMessage, Message: &models.Message{
+				ID: &id, ChatID: &chatID, Seq: &seq, Body: &body, Sender: &sender, Receiver: &receiver, CreatedAt: &now,
+			}},
+			want: &models.LegacyFrame{Type: models.TypeMessage, ID: &id, ChatID: &chatID, Seq: &seq, Body: &body,
+				Sender: &sender, Receiver: &receiver, CreatedAt: &now},
+		},
+		{
+			name: "group message",
+			frame: &models.Envelope{Type: models.TypeMessage, Message: &models.Message{
+				ID: &id, ChatID: &chatID, Seq: &seq, Body: &body, Sender: &sender, CreatedAt: &now,
+			}},
+		},
+		{
+			name:  "presence online",
+			frame: &models.Envelope{Type: models.TypePresence, Presence: &models.Presence{Username: sender, Online: true}},
+			want:  &models.LegacyFrame{Type: models.TypePresence, Sender: &sender, State: "online"},
+		},
+		{
+			name: "presence offline",
+			frame: &models.Envelope{Type: models.TypePresence, Presence: &models.Presence{Username: sender,
+				LastSeenAt: &now}},
+			want: &models.LegacyFrame{Type: models.TypePresence, Sender: &sender, State: "offline", CreatedAt: &now},
+		},
+		{
+			name: "ack",
+			frame: &models.Envelope{Type: models.TypeAck, Ack: &models.Ack{ID: id, ChatID: chatID, Seq: seq,
+				CreatedAt: now}},
+		},
+		{
+			name:  "receipt",
+			frame: &models.Envelope{Type: models.TypeReceipt, Receipt: &models.Receipt{ChatID: chatID, MessageID: id}},
+		},
+		{
+			name:  "typing",
+			frame: &models.Envelope{Type: models.TypeTyping, Typing: &models.Typing{ChatID: chatID, State: "start"}},
+		},
+		{
+			name:  "system",
+			frame: &models.Envelope{Type: models.TypeSystem, System: &models.System{ChatID: chatID, Body: body}},
+		},
+		{
+			name:  "error",
+			frame: &models.Envelope{Type: models.TypeError, Error: &models.Error{ChatID: &chatID, Code: "invalid"}},
+		},
+	} {
+		require.Equal(t, tc.want, models.NewLegacyFrame(tc.frame), tc.name)
+	}
+}
+
+func TestLegacyFrameEnvelope(t *testing.T) {
+	id, chatID := 7, 3
+	body, receiver := "hello", "bob"
+
+	for _, tc := range []struct {
+		name  string
+		frame *models.LegacyFrame
+		want  *models.Envelope
+	}{
+		{
+			name:  "untyped message",
+			frame: &models.LegacyFrame{Body: &body, Receiver: &receiver},
+			want:  &models.Envelope{Type: models.TypeMessage, Message: &models.Message{Body: &body, Receiver: &receiver}},
+		},
+		{
+			name:  "read",
+			frame: &models.LegacyFrame{Type: models.TypeRead, ID: &id, ChatID: &chatID},
+			want:  &models.Envelope{Type: models.TypeRead, Read: &models.Read{ChatID: chatID, MessageID: id}},
+		},
+		{
+			name:  "read without chat",
+			frame: &models.LegacyFrame{Type: models.TypeRead, ID: &id},
+			want:  &models.Envelope{Type: models.TypeRead},
+		},
+		{
+			name:  "typing",
+			frame: &models.LegacyFrame{Type: models.TypeTyping, ChatID: &chatID, State: "stop"},
+			want:  &models.Envelope{Type: models.TypeTyping, Typing: &models.Typing{ChatID: chatID, State: "stop"}},
+		},
+		{
+			name:  "unknown",
+			frame: &models.LegacyFrame{Type: "wave"},
+		},
+	} {
+		require.Equal(t, tc.want, tc.frame.Envelope(), tc.name)
+	}
+}
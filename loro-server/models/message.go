@@ -1,9 +1,64 @@
 package models
 
-type Message struct {
-	ID       *int    `json:"id,omitempty"`
-	Body     *string `json:"body,omitempty"`
-	Sender   *string `json:"sender,omitempty"`
-	Receiver *string `json:"receiver,omitempty"`
-	ChatID   *int    `json:"chatId,omitempty"`
-}
+import protocol "loro-protocol"
+
+// Frames are defined in the protocol module shared with the clients
+type (
+	Envelope   = protocol.Envelope
+	Message    = protocol.Message
+	Ack        = protocol.Ack
+	Error      = protocol.Error
+	Read       = protocol.Read
+	Receipt    = protocol.Receipt
+	Typing     = protocol.Typing
+	Presence   = protocol.Presence
+	Resume     = protocol.Resume
+	SeqCursor  = protocol.SeqCursor
+	Resumed    = protocol.Resumed
+	System     = protocol.System
+	Edit       = protocol.Edit
+	Retract    = protocol.Retract
+	Reaction   = protocol.Reaction
+	Quote      = protocol.Quote
+	Mention    = protocol.Mention
+	Pin        = protocol.Pin
+	Unread     = protocol.Unread
+	Block      = protocol.Block
+	Contact    = protocol.Contact
+	Attachment = protocol.Attachment
+)
+
+const (
+	TypeMessage  = protocol.TypeMessage
+	TypeAck      = protocol.TypeAck
+	TypeError    = protocol.TypeError
+	TypeRead     = protocol.TypeRead
+	TypeReceipt  = protocol.TypeReceipt
+	TypeTyping   = protocol.TypeTyping
+	TypePresence = protocol.TypePresence
+	TypeResume   = protocol.TypeResume
+	TypeResumed  = protocol.TypeResumed
+	TypeSystem   = protocol.TypeSystem
+	TypeEdit     = protocol.TypeEdit
+	TypeRetract  = protocol.TypeRetract
+	TypeReaction = protocol.TypeReaction
+	TypeMention  = protocol.TypeMention
+	TypePin      = protocol.TypePin
+	TypeUnread   = protocol.TypeUnread
+	TypeBlock    = protocol.TypeBlock
+	TypeContact  = protocol.TypeContact
+
+	TypingStart = protocol.TypingStart
+	TypingStop  = protocol.TypingStop
+
+	ContactRequested = protocol.ContactRequested
+	ContactAccepted  = protocol.ContactAccepted
+	ContactDeclined  = protocol.ContactDeclined
+	ContactRemoved   = protocol.ContactRemoved
+
+	ErrCodeInvalid   = protocol.ErrCodeInvalid
+	ErrCodeNotMember = protocol.ErrCodeNotMember
+	ErrCodeNotFound  = protocol.ErrCodeNotFound
+	ErrCodeInternal  = protocol.ErrCodeInternal
+	ErrCodeForbidden = protocol.ErrCodeForbidden
+)
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type Session struct {
+	ID          string    `json:"id"`
+	Device      string    `json:"device"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Dropped     uint64    `json:"dropped"`
+}
+
+type HubStats struct {
+	Users         int    `json:"users"`
+	Sessions      int    `json:"sessions"`
+	DroppedFrames uint64 `json:"dropped_frames"`
+	Evicted       uint64 `json:"evicted"`
+	// deliveries other instances never got because the broker fell behind
+	Unpublished uint64 `json:"unpublished"`
+}
+
+type BlockedUser struct {
+	Username  string    `json:"username"`
+	BlockedAt time.Time `json:"blocked_at"`
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/core"
+	"server/models"
+	"server/storage"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// uploads not sent in a message are deleted once this old
+	unsentUploadAge = 24 * time.Hour
+	sweepInterval   = time.Hour
+)
+
+var (
+	ErrFileTooLarge = errors.New("file is too large")
+	ErrFileType     = errors.New("file type is not allowed")
+)
+
+// AttachmentLimits bound what can be uploaded to a chat
+type AttachmentLimits struct {
+	// size of a file in bytes
+	MaxSize int64
+	// content types allowed, one ending in / allows every type under it
+	Types []string
+}
+
+/*
+loadAttachmentLimits reads ATTACHMENT_MAX_SIZE in bytes and ATTACHMENT_TYPES
+separated by commas.
+*/
+func loadAttachmentLimits() AttachmentLimits {
+	limits := AttachmentLimits{
+		MaxSize: 10 << 20,
+		Types:   []string{"image/", "audio/", "video/", "text/plain", "application/pdf", "application/zip"},
+	}
+
+	if value := os.Getenv("ATTACHMENT_MAX_SIZE"); value != "" {
+		if size, err := strconv.ParseInt(value, 10, 64); err == nil && size > 0 {
+			limits.MaxSize = size
+		} else {
+			log.Printf("ATTACHMENT_MAX_SIZE=%s is not a size in bytes, keeping %d", value, limits.MaxSize)
+		}
+	}
+	if value := os.Getenv("ATTACHMENT_TYPES"); value != "" {
+		limits.Types = strings.Split(value, ",")
+	}
+
+	return limits
+}
+
+func (l AttachmentLimits) allows(contentType string) bool {
+	for _, allowed := range l.Types {
+		allowed = strings.TrimSpace(allowed)
+		if contentType == allowed || strings.HasSuffix(allowed, "/") && strings.HasPrefix(contentType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// newStorage picks where attachments are kept
+func newStorage() storage.Storage {
+	switch backend := os.Getenv("ATTACHMENT_STORAGE"); backend {
+	case "", "local":
+		dir := os.Getenv("ATTACHMENT_DIR")
+		if dir == "" {
+			dir = "attachments"
+		}
+		local, err := storage.NewLocal(dir)
+		if err != nil {
+			log.Fatalf("attachment directory %s: %v", dir, err)
+		}
+		return local
+	default:
+		log.Fatalf("ATTACHMENT_STORAGE=%s is not supported", backend)
+		return nil
+	}
+}
+
+// MaxUpload is the largest file accepted, in bytes
+func (svc ChatService) MaxUpload() int64 {
+	return svc.limits.MaxSize
+}
+
+/*
+Upload stores a file for a member to send in the chat. The content type is
+sniffed from the file itself, whatever the client claims. The file is linked
+to a message once the member sends one with it.
+*/
+func (svc ChatService) Upload(username string, chatID int, filename string, content io.Reader) (*models.Attachment, error) {
+	if err := svc.checkMember(username, chatID); err != nil {
+		return nil, err
+	}
+
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !svc.limits.allows(contentType) {
+		return nil, ErrFileType
+	}
+
+	// one byte over the limit is enough to tell the file is too large
+	counter := &countingReader{reader: io.LimitReader(io.MultiReader(bytes.NewReader(head), content), svc.limits.MaxSize+1)}
+	key := storage.NewKey()
+	if err := svc.storage.Put(context.Background(), key, counter); err != nil {
+		return nil, err
+	}
+	if counter.n > svc.limits.MaxSize {
+		_ = svc.storage.Delete(context.Background(), key)
+		return nil, ErrFileTooLarge
+	}
+
+	attachment := &models.Attachment{Filename: filepath.Base(filename), ContentType: contentType, Size: counter.n}
+	err = svc.pool.QueryRow(context.Background(), `insert into attachments(chat_id, uploader_id, storage_key, filename, content_type, size)
+		values($1, $2, $3, $4, $5, $6) returning id`,
+		chatID, userID, key, attachment.Filename, attachment.ContentType, attachment.Size).Scan(&attachment.ID)
+	if err != nil {
+		_ = svc.storage.Delete(context.Background(), key)
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+/*
+Download opens a file sent in a chat of the user. Files not sent yet are only
+for whoever uploaded them and files of deleted messages are gone for everyone.
+The caller closes the content.
+*/
+func (svc ChatService) Download(username string, attachmentID int) (*models.Attachment, io.ReadCloser, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var key string
+	var uploaderID *uint
+	var sent, deleted bool
+	attachment := &models.Attachment{ID: attachmentID}
+	err = svc.pool.QueryRow(context.Background(), `select a.storage_key, a.filename, a.content_type, a.size,
+		a.uploader_id, m.id is not null, m.deleted_at is not null
+		from attachments a
+		inner join chat_members cm on cm.chat_id = a.chat_id and cm.user_id = $2
+		left join messages m on m.id = a.message_id
+		where a.id = $1`,
+		attachmentID, userID).Scan(&key, &attachment.Filename, &attachment.ContentType, &attachment.Size,
+		&uploaderID, &sent, &deleted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, core.ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !downloadable(userID, uploaderID, sent, deleted) {
+		return nil, nil, core.ErrAttachmentNotFound
+	}
+
+	content, err := svc.storage.Get(context.Background(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, fmt.Errorf("attachment %d lost its file: %w", attachmentID, core.ErrAttachmentNotFound)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return attachment, content, nil
+}
+
+// downloadable tells whether a member of the chat may download a file, the rule Download follows
+func downloadable(userID uint, uploaderID *uint, sent, deleted bool) bool {
+	if !sent {
+		return uploaderID != nil && *uploaderID == userID
+	}
+	return !deleted
+}
+
+// sweepUploads deletes the uploads never sent every sweepInterval
+func (svc ChatService) sweepUploads() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := svc.deleteUnsent(); err != nil {
+			log.Println("Error deleting unsent uploads:", err.Error())
+		}
+	}
+}
+
+// deleteUnsent deletes the uploads older than unsentUploadAge that no message took
+func (svc ChatService) deleteUnsent() error {
+	keys, err := deleteAttachments(svc.pool, `message_id is null and created_at < $1`, time.Now().Add(-unsentUploadAge))
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := svc.storage.Delete(context.Background(), key); err != nil {
+			log.Printf("Unsent upload %s not deleted: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// deleteAttachments deletes the attachments matching the condition and returns the keys of their files
+func deleteAttachments(q querier, condition string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(context.Background(), `delete from attachments where `+condition+` returning storage_key`,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadableKeepsUnsentFilesForTheirUploader(t *testing.T) {
+	uploader, member := uint(1), uint(2)
+
+	for _, tc := range []struct {
+		name       string
+		userID     uint
+		uploaderID *uint
+		sent       bool
+		deleted    bool
+		want       bool
+	}{
+		{"uploader before sending", uploader, &uploader, false, false, true},
+		{"member before sending", member, &uploader, false, false, false},
+		{"deleted uploader before sending", member, nil, false, false, false},
+		{"uploader once sent", uploader, &uploader, true, false, true},
+		{"member once sent", member, &uploader, true, false, true},
+		{"sender of a deleted message", uploader, &uploader, true, true, false},
+		{"member of a deleted message", member, &uploader, true, true, false},
+	} {
+		require.Equal(t, tc.want, downloadable(tc.userID, tc.uploaderID, tc.sent, tc.deleted), tc.name)
+	}
+}
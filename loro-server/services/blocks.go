@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+
+	"server/core"
+	"server/db/utils"
+	"server/models"
+)
+
+// GetBlocks lists the users the user blocked, the latest first
+func (svc ChatService) GetBlocks(username string) ([]models.BlockedUser, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := svc.pool.Query(context.Background(), `select u.username, ub.created_at from user_blocks ub
+		inner join users u on u.id = ub.blocked_id where ub.blocker_id = $1
+		order by ub.created_at desc`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := make([]models.BlockedUser, 0)
+	for rows.Next() {
+		block := models.BlockedUser{}
+		if err := rows.Scan(&block.Username, &block.BlockedAt); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, rows.Err()
+}
+
+// Block blocks or unblocks the user named for the user
+func (svc ChatService) Block(username, blocked string, block bool) (*models.Block, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	user := utils.User{ID: &userID, Username: &username}
+	return core.BlockUser(svc.pool, svc.socketManager, &user, blocked, block)
+}
@@ -2,29 +2,78 @@ package services
 
 import (
 	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
 
 	"server/core"
 	"server/db"
 	"server/db/utils"
+	"server/models"
+	"server/storage"
+
+	protocol "loro-protocol"
 
 	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
 )
 
 type ChatService struct {
 	pool          *db.PostgresPool
 	socketManager *core.SocketManager
+	storage       storage.Storage
+	limits        AttachmentLimits
+	// users allowed to read the counters of the hub
+	admins map[string]bool
 }
 
+var ErrNotAdmin = errors.New("only admins can read hub stats")
+
 func NewChatService(pool *db.PostgresPool) ChatService {
-	newSocketManager := core.NewSocketManager()
+	files := newStorage()
+	newSocketManager := core.NewSocketManager(newBroker(pool), files, core.LoadConfig())
 	go newSocketManager.Run()
-	return ChatService{pool: pool, socketManager: newSocketManager}
+	svc := ChatService{
+		pool:          pool,
+		socketManager: newSocketManager,
+		storage:       files,
+		limits:        loadAttachmentLimits(),
+		admins:        loadAdmins(),
+	}
+	go svc.sweepUploads()
+	return svc
+}
+
+// loadAdmins reads the usernames in STATS_ADMINS separated by commas, nobody by default
+func loadAdmins() map[string]bool {
+	admins := make(map[string]bool)
+	for _, username := range strings.Split(os.Getenv("STATS_ADMINS"), ",") {
+		if username = strings.TrimSpace(username); username != "" {
+			admins[username] = true
+		}
+	}
+	return admins
+}
+
+// newBroker picks how messages reach users connected to other instances
+func newBroker(pool *db.PostgresPool) core.Broker {
+	switch os.Getenv("HUB_BROKER") {
+	case "postgres":
+		return core.NewPostgresBroker(pool)
+	default:
+		return core.NewLocalBroker()
+	}
 }
 
-func (svc ChatService) GetMembers(chatID int) ([]utils.User, error) {
+func (svc ChatService) GetMembers(username string, chatID int) ([]utils.User, error) {
+	if err := svc.checkMember(username, chatID); err != nil {
+		return nil, err
+	}
+
 	members := make([]utils.User, 0)
 
-	rows, err := svc.pool.Query(context.Background(), `select u.username, u.public_key from users u 
+	rows, err := svc.pool.Query(context.Background(), `select u.username, u.public_key, cm.role from users u 
 	inner join chat_members cm on u.id = cm.user_id where cm.chat_id = $1`, chatID)
 	if err != nil {
 		return nil, err
@@ -32,7 +81,7 @@ func (svc ChatService) GetMembers(chatID int) ([]utils.User, error) {
 
 	for rows.Next() {
 		member := utils.User{}
-		err := rows.Scan(&member.Username, &member.PublicKey)
+		err := rows.Scan(&member.Username, &member.PublicKey, &member.Role)
 		if err != nil {
 			return nil, err
 		}
@@ -43,21 +92,169 @@ func (svc ChatService) GetMembers(chatID int) ([]utils.User, error) {
 	return members, nil
 }
 
-func (svc ChatService) GetMessages(chatID, limit, offset int) ([]utils.Message, error) {
-	messages := make([]utils.Message, 0)
-	rows, err := svc.pool.Query(context.Background(), `select distinct m.id, m.body, m.created_at, u.username 
+// messageCursor holds where the next page of messages starts, only one of both is set
+type messageCursor struct {
+	Before *int64 `json:"before,omitempty"`
+	After  *int64 `json:"after,omitempty"`
+}
+
+/*
+GetMessages returns a page of the messages of the chat by sequence. Without
+a position it starts with the newest message and goes back, before a sequence
+it goes back from there and after a sequence it goes forward, oldest first.
+Messages arriving meanwhile never move a page. The cursor of the page
+continues in the same direction and is absent once the chat has no more.
+*/
+func (svc ChatService) GetMessages(username string, chatID int, query models.MessagesQuery) (*utils.MessagePage, error) {
+	if err := svc.checkMember(username, chatID); err != nil {
+		return nil, err
+	}
+
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	position := messageCursor{Before: query.Before, After: query.After}
+	if query.Cursor != "" {
+		if err := decodeCursor(query.Cursor, &position); err != nil {
+			return nil, err
+		}
+	}
+	if position.Before != nil && position.After != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	// one message more than asked tells whether there is a next page
+	condition, order, seq := `($2::int8 is null or cm.seq < $2)`, "desc", position.Before
+	if position.After != nil {
+		condition, order, seq = `cm.seq > $2`, "asc", position.After
+	}
+	rows, err := svc.pool.Query(context.Background(), `select `+messageColumns+`
 		from messages m 
 		inner join chat_messages cm on m.id = cm.message_id
 		inner join users u on m.user_messages = u.id
+		left join hidden_messages h on h.message_id = m.id and h.user_id = $4
+		where cm.chat_id = $1 and `+condition+`
+		order by cm.seq `+order+` limit $3`, chatID, seq, query.Limit+1, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &utils.MessagePage{Messages: messages}
+	if len(messages) > query.Limit {
+		page.Messages = messages[:query.Limit]
+		last := *page.Messages[query.Limit-1].Seq
+		next := messageCursor{Before: &last}
+		if position.After != nil {
+			next = messageCursor{After: &last}
+		}
+		cursor := encodeCursor(next)
+		page.NextCursor = &cursor
+	}
+	return page, nil
+}
+
+// GetMentions returns the mentions of the user not read yet, newest first
+func (svc ChatService) GetMentions(username string) ([]models.Mention, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := svc.pool.Query(context.Background(), `select cm.chat_id, m.id, cm.seq, u.username, m.body, m.created_at
+		from message_mentions mm
+		inner join messages m on m.id = mm.message_id
+		inner join chat_messages cm on cm.message_id = m.id
+		inner join chat_members me on me.chat_id = cm.chat_id and me.user_id = mm.user_id
+		inner join users u on u.id = m.user_messages
+		where mm.user_id = $1 and mm.read_at is null and m.deleted_at is null
+		order by m.created_at desc limit 100`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := make([]models.Mention, 0)
+	for rows.Next() {
+		mention := models.Mention{}
+		err := rows.Scan(&mention.ChatID, &mention.MessageID, &mention.Seq, &mention.Sender, &mention.Body, &mention.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, mention)
+	}
+
+	return mentions, rows.Err()
+}
+
+/*
+GetThread returns the replies to a message and the replies to those, oldest
+first.
+*/
+func (svc ChatService) GetThread(username string, chatID, messageID, limit, offset int) ([]utils.Message, error) {
+	if err := svc.checkMember(username, chatID); err != nil {
+		return nil, err
+	}
+
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	var found bool
+	err = svc.pool.QueryRow(context.Background(), `select exists(select 1 from chat_messages
+		where chat_id = $1 and message_id = $2)`, chatID, messageID).Scan(&found)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, core.ErrMessageNotFound
+	}
+
+	rows, err := svc.pool.Query(context.Background(), `with recursive thread as (
+			select id from messages where reply_to = $2
+			union
+			select r.id from messages r inner join thread t on r.reply_to = t.id
+		)
+		select `+messageColumns+`
+		from thread t
+		inner join messages m on m.id = t.id
+		inner join chat_messages cm on m.id = cm.message_id
+		inner join users u on m.user_messages = u.id
+		left join hidden_messages h on h.message_id = m.id and h.user_id = $5
 		where cm.chat_id = $1
-		order by m.created_at desc limit $2 offset $3`, chatID, limit, offset)
+		order by cm.seq limit $3 offset $4`, chatID, messageID, limit, offset, userID)
 	if err != nil {
 		return nil, err
 	}
 
+	return scanMessages(rows)
+}
+
+/*
+messageColumns are the columns scanMessages reads from messages m, their
+chat_messages cm, sender u and the hidden_messages h of the user. Deleted and
+hidden messages come as tombstones so pages keep their size.
+*/
+const messageColumns = `m.id,
+	case when m.deleted_at is null and h.hidden_at is null then m.body end, m.created_at, u.username, cm.seq,
+	m.edited_at, coalesce(m.deleted_at, h.hidden_at), ` + core.ReactionCounts + `, m.reply_to, ` + core.QuotedParent + `,
+	case when m.deleted_at is null and h.hidden_at is null then ` + core.AttachedFile + ` end`
+
+func scanMessages(rows pgx.Rows) ([]utils.Message, error) {
+	defer rows.Close()
+
+	messages := make([]utils.Message, 0)
 	for rows.Next() {
 		msg := utils.Message{}
-		err := rows.Scan(&msg.ID, &msg.Body, &msg.CreatedAt, &msg.Sender)
+		err := rows.Scan(&msg.ID, &msg.Body, &msg.CreatedAt, &msg.Sender, &msg.Seq, &msg.EditedAt, &msg.DeletedAt,
+			&msg.Reactions, &msg.ReplyTo, &msg.Quote, &msg.Attachment)
 		if err != nil {
 			return nil, err
 		}
@@ -65,39 +262,61 @@ func (svc ChatService) GetMessages(chatID, limit, offset int) ([]utils.Message,
 		messages = append(messages, msg)
 	}
 
-	return messages, nil
+	return messages, rows.Err()
 }
 
-func (svc ChatService) GetChats(username string) ([]utils.Chat, error) {
-	user := utils.User{}
-	err := svc.pool.QueryRow(context.Background(), `select u.id from users u where username = $1`, username).
-		Scan(&user.ID)
+// GetChats lists the chats of the user the filter keeps, the latest active first
+func (svc ChatService) GetChats(username, filter string) ([]utils.Chat, error) {
+	var state string
+	switch filter {
+	case "", models.ChatsInbox:
+		state = "not me.archived and not me.hidden"
+	case models.ChatsArchived:
+		state = "me.archived and not me.hidden"
+	case models.ChatsHidden:
+		state = "me.hidden"
+	case models.ChatsAll:
+		state = "true"
+	default:
+		return nil, ErrInvalidFilter
+	}
+
+	userID, err := svc.userID(username)
 	if err != nil {
 		return nil, err
 	}
 
 	chats := make([]utils.Chat, 0)
+	// direct chats are named after the other member, groups after themselves
 	rows, err := svc.pool.Query(context.Background(),
-		`with user_chats as (
-			select distinct on (c.id) c.id, m.created_at as last_message_time, m.body as last_message from chats c 
-			inner join chat_messages cm on c.id = cm.chat_id
+		`select peer.username, c.id, c.type, c.name, lm.created_at as last_message_time, lm.body as last_message, c.last_seq,
+		`+core.UnreadCount+` as unread, me.archived, me.hidden,
+		case when me.muted_until > now() then me.muted_until end
+		from chats c
+		inner join chat_members me on c.id = me.chat_id and me.user_id = $1
+		left join lateral (
+			select u.username from chat_members cm
+			inner join users u on cm.user_id = u.id
+			where cm.chat_id = c.id and cm.user_id != $1
+			order by u.username limit 1
+		) peer on c.type = $2
+		left join lateral (
+			select m.body, m.created_at from chat_messages cm
 			inner join messages m on cm.message_id = m.id
-			where c.id in (
-				select distinct chat_id from chat_members
-				where user_id = $1
-			) order by c.id, m.created_at desc
-		) select u.username, uc.* from user_chats uc 
-		inner join chat_members cm on uc.id = cm.chat_id
-		inner join users u on cm.user_id = u.id
-		where cm.user_id != $1 
-		order by uc.last_message_time desc`, *user.ID)
+			where cm.chat_id = c.id and m.deleted_at is null
+			and not exists(select 1 from hidden_messages h where h.message_id = m.id and h.user_id = $1)
+			order by cm.seq desc limit 1
+		) lm on true
+		where `+state+`
+		order by coalesce(lm.created_at, c.created_at) desc`, userID, core.ChatTypeDirect)
 	if err != nil {
 		return nil, err
 	}
 
 	for rows.Next() {
 		chat := utils.Chat{}
-		err := rows.Scan(&chat.RecipientUsername, &chat.ID, &chat.LastMessageTime, &chat.LastMessage)
+		err := rows.Scan(&chat.RecipientUsername, &chat.ID, &chat.Type, &chat.Name, &chat.LastMessageTime, &chat.LastMessage, &chat.LastSeq, &chat.Unread,
+			&chat.Archived, &chat.Hidden, &chat.MutedUntil)
 		if err != nil {
 			return nil, err
 		}
@@ -108,22 +327,163 @@ func (svc ChatService) GetChats(username string) ([]utils.Chat, error) {
 	return chats, nil
 }
 
-func (svc ChatService) Subscribe(username string, ws *websocket.Conn) error {
-	user := utils.User{}
-	err := svc.pool.QueryRow(context.Background(), `select u.id from users u where username = $1`, username).
-		Scan(&user.ID)
+/*
+Subscribe serves the websocket of a new session speaking the protocol version
+and codec agreed at the handshake. A resuming client gets no live frames until it sent
+its resume and the missed messages were replayed.
+*/
+func (svc ChatService) Subscribe(username, sessionID, device string, version int, resume bool, ws *websocket.Conn) error {
+	userID, err := svc.userID(username)
 	if err != nil {
 		return err
 	}
-	user.Username = &username
+	user := utils.User{ID: &userID, Username: &username}
 
-	newConnection := &core.Connection{
-		User:          &user,
-		Conn:          ws,
-		SocketManager: svc.socketManager,
-		Pool:          svc.pool,
+	newConnection := core.NewConnection(&user, ws, svc.socketManager, svc.pool, sessionID, device)
+	if err := newConnection.LoadBlocks(); err != nil {
+		return err
+	}
+	newConnection.Version = version
+	newConnection.Codec = protocol.CodecFor(ws.Subprotocol())
+	if resume {
+		newConnection.HoldLive()
 	}
 	svc.socketManager.Join <- newConnection
 
 	return newConnection.Listen()
 }
+
+func (svc ChatService) PingInterval() time.Duration {
+	return svc.socketManager.PingInterval()
+}
+
+func (svc ChatService) GetSessions(username string) []models.Session {
+	return svc.socketManager.Sessions(username)
+}
+
+func (svc ChatService) Shutdown() {
+	svc.socketManager.Shutdown()
+}
+
+// GetStats returns the counters of the hub, which tell about every user and so are for admins only
+func (svc ChatService) GetStats(username string) (models.HubStats, error) {
+	if !svc.admins[username] {
+		return models.HubStats{}, ErrNotAdmin
+	}
+	return svc.socketManager.Stats(), nil
+}
+
+func (svc ChatService) MarkRead(username string, chatID, messageID int) error {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return err
+	}
+
+	user := utils.User{ID: &userID, Username: &username}
+	return core.MarkRead(svc.pool, svc.socketManager, &user, chatID, messageID)
+}
+
+func (svc ChatService) EditMessage(username string, chatID, messageID int, body string) (*models.Edit, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	user := utils.User{ID: &userID, Username: &username}
+	return core.EditMessage(svc.pool, svc.socketManager, &user, chatID, messageID, body)
+}
+
+// ToggleReaction sets or removes the emoji as the reaction of the user on the message
+func (svc ChatService) ToggleReaction(username string, chatID, messageID int, emoji string) (*models.Reaction, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	user := utils.User{ID: &userID, Username: &username}
+	return core.ToggleReaction(svc.pool, svc.socketManager, &user, chatID, messageID, emoji)
+}
+
+// DeleteMessage deletes the message for everyone or only for the user
+func (svc ChatService) DeleteMessage(username string, chatID, messageID int, forEveryone bool) (*models.Retract, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	user := utils.User{ID: &userID, Username: &username}
+	if forEveryone {
+		return core.RetractMessage(svc.pool, svc.socketManager, &user, chatID, messageID)
+	}
+	return core.HideMessage(svc.pool, svc.socketManager, &user, chatID, messageID)
+}
+
+// PinMessage pins the message to the top of its chat or unpins it
+func (svc ChatService) PinMessage(username string, chatID, messageID int, pinned bool) (*models.Pin, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	user := utils.User{ID: &userID, Username: &username}
+	return core.PinMessage(svc.pool, svc.socketManager, &user, chatID, messageID, pinned)
+}
+
+func (svc ChatService) GetPins(username string, chatID int) ([]models.Pin, error) {
+	if err := svc.checkMember(username, chatID); err != nil {
+		return nil, err
+	}
+
+	return core.Pins(svc.pool, chatID)
+}
+
+func (svc ChatService) GetRevisions(username string, chatID, messageID int) ([]utils.Revision, error) {
+	if err := svc.checkMember(username, chatID); err != nil {
+		return nil, err
+	}
+
+	return core.Revisions(svc.pool, chatID, messageID)
+}
+
+func (svc ChatService) GetReceipts(username string, chatID int) ([]utils.ReadCursor, error) {
+	if err := svc.checkMember(username, chatID); err != nil {
+		return nil, err
+	}
+
+	return core.ReadCursors(svc.pool, chatID)
+}
+
+func (svc ChatService) GetPresence(username string, usernames []string) ([]models.Presence, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	return core.Presence(svc.pool, svc.socketManager, userID, usernames)
+}
+
+func (svc ChatService) userID(username string) (uint, error) {
+	var id uint
+	err := svc.pool.QueryRow(context.Background(), `select u.id from users u where username = $1`, username).
+		Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrUserNotFound
+	}
+	return id, err
+}
+
+func (svc ChatService) checkMember(username string, chatID int) error {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return err
+	}
+
+	ok, err := core.IsMember(context.Background(), svc.pool, chatID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return core.ErrNotMember
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"server/core"
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrInvalidFilter = errors.New("chats filter must be inbox, archived, hidden or all")
+
+/*
+SetChatState archives, hides or mutes the chat for the user alone, changing
+only what the update sets, and returns the whole state. A mute that already
+ended is stored as none.
+*/
+func (svc ChatService) SetChatState(username string, chatID int, update models.ChatStateUpdate) (*models.ChatState, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &models.ChatState{}
+	err = svc.pool.QueryRow(context.Background(), `update chat_members set archived = coalesce($3, archived),
+		hidden = coalesce($4, hidden),
+		muted_until = case when $5::timestamptz is null then muted_until when $5 > now() then $5 end
+		where chat_id = $1 and user_id = $2
+		returning archived, hidden, case when muted_until > now() then muted_until end`,
+		chatID, userID, update.Archived, update.Hidden, update.MutedUntil).
+		Scan(&state.Archived, &state.Hidden, &state.MutedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, core.ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
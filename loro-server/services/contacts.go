@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"server/core"
+	"server/db/utils"
+	"server/models"
+)
+
+// longest nickname a contact can be given, in characters
+const maxNickname = 64
+
+var ErrInvalidNickname = fmt.Errorf("nickname is longer than %d characters", maxNickname)
+
+/*
+GetContacts lists the contacts of the user by the name shown for them, with
+whether they are online. Users blocked either way are left out.
+*/
+func (svc ChatService) GetContacts(username string) ([]utils.Contact, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := svc.pool.Query(context.Background(), `select u.username, c.nickname, u.last_seen_at, c.created_at
+		from contacts c inner join users u on u.id = c.contact_id
+		where c.user_id = $1 and not exists(select 1 from user_blocks ub
+			where ub.blocker_id = $1 and ub.blocked_id = u.id or ub.blocker_id = u.id and ub.blocked_id = $1)
+		order by lower(coalesce(c.nickname, u.username))`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := make([]utils.Contact, 0)
+	for rows.Next() {
+		contact := utils.Contact{}
+		if err := rows.Scan(&contact.Username, &contact.Nickname, &contact.LastSeenAt, &contact.Since); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	usernames := make([]string, 0, len(contacts))
+	for _, contact := range contacts {
+		usernames = append(usernames, contact.Username)
+	}
+	online := svc.socketManager.Online(usernames)
+	for i := range contacts {
+		contacts[i].Online = online[contacts[i].Username]
+	}
+	return contacts, nil
+}
+
+// GetContactRequests lists the contact requests waiting for the user and the ones they sent
+func (svc ChatService) GetContactRequests(username string) (*utils.ContactRequests, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := svc.pool.Query(context.Background(), `select u.username, cr.created_at, cr.to_id = $1
+		from contact_requests cr inner join users u on u.id = case when cr.to_id = $1 then cr.from_id else cr.to_id end
+		where cr.to_id = $1 or cr.from_id = $1 order by cr.created_at desc`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := &utils.ContactRequests{
+		Incoming: make([]utils.ContactRequest, 0),
+		Outgoing: make([]utils.ContactRequest, 0),
+	}
+	for rows.Next() {
+		request := utils.ContactRequest{}
+		var incoming bool
+		if err := rows.Scan(&request.Username, &request.CreatedAt, &incoming); err != nil {
+			return nil, err
+		}
+		if incoming {
+			requests.Incoming = append(requests.Incoming, request)
+		} else {
+			requests.Outgoing = append(requests.Outgoing, request)
+		}
+	}
+	return requests, rows.Err()
+}
+
+// SetNickname names a contact for the user alone, an empty nickname shows their username again
+func (svc ChatService) SetNickname(username, contact, nickname string) error {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return err
+	}
+
+	var name *string
+	if nickname = strings.TrimSpace(nickname); nickname != "" {
+		if len([]rune(nickname)) > maxNickname {
+			return ErrInvalidNickname
+		}
+		name = &nickname
+	}
+	tag, err := svc.pool.Execute(context.Background(), `update contacts set nickname = $3
+		where user_id = $1 and contact_id = (select id from users where username = $2)`, userID, contact, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return core.ErrContactNotFound
+	}
+	return nil
+}
+
+// RequestContact asks the user named to become a contact, or accepts when they asked first
+func (svc ChatService) RequestContact(username, contact string) (*models.Contact, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	user := utils.User{ID: &userID, Username: &username}
+	return core.RequestContact(svc.pool, svc.socketManager, &user, contact)
+}
+
+func (svc ChatService) AcceptContact(username, contact string) (*models.Contact, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	user := utils.User{ID: &userID, Username: &username}
+	return core.AcceptContact(svc.pool, svc.socketManager, &user, contact)
+}
+
+// DeclineContact declines a request sent to the user or withdraws one they sent
+func (svc ChatService) DeclineContact(username, contact string) (*models.Contact, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	user := utils.User{ID: &userID, Username: &username}
+	return core.DeclineContact(svc.pool, svc.socketManager, &user, contact)
+}
+
+func (svc ChatService) RemoveContact(username, contact string) (*models.Contact, error) {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	user := utils.User{ID: &userID, Username: &username}
+	return core.RemoveContact(svc.pool, svc.socketManager, &user, contact)
+}
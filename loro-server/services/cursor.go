@@ -0,0 +1,26 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalidCursor = errors.New("cursor is not valid")
+
+// cursors are opaque to clients, so what they hold can change without breaking them
+func encodeCursor(position any) string {
+	bytes, _ := json.Marshal(position)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func decodeCursor(cursor string, position any) error {
+	bytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	if err := json.Unmarshal(bytes, position); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursorsRoundTrip(t *testing.T) {
+	seq := int64(42)
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name     string
+		position any
+		decoded  any
+	}{
+		{"older messages", messageCursor{Before: &seq}, &messageCursor{}},
+		{"newer messages", messageCursor{After: &seq}, &messageCursor{}},
+		{"search hits", searchCursor{CreatedAt: created, ID: 7}, &searchCursor{}},
+	} {
+		cursor := encodeCursor(tc.position)
+		require.NotContains(t, cursor, "=", tc.name)
+		require.NoError(t, decodeCursor(cursor, tc.decoded), tc.name)
+
+		switch decoded := tc.decoded.(type) {
+		case *messageCursor:
+			require.Equal(t, tc.position, *decoded, tc.name)
+		case *searchCursor:
+			require.Equal(t, tc.position, *decoded, tc.name)
+		}
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, cursor := range []string{
+		"not base64!",
+		encodeCursor("a string")[:3],
+		"bm90IGpzb24", // "not json"
+		encodeCursor("a string"),
+	} {
+		position := messageCursor{}
+		require.ErrorIs(t, decodeCursor(cursor, &position), ErrInvalidCursor, cursor)
+	}
+}
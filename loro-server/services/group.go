@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"server/core"
+	"server/db/utils"
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrUserNotFound = core.ErrUserNotFound
+	ErrNotGroup     = errors.New("chat is not a group")
+	ErrNotOwner     = errors.New("only the group owner can change its members")
+	ErrInvalidGroup = errors.New("group needs a name")
+)
+
+func (svc ChatService) CreateGroup(username string, group models.GroupRequest) (*utils.Chat, error) {
+	name := strings.TrimSpace(group.Name)
+	if name == "" {
+		return nil, ErrInvalidGroup
+	}
+
+	ownerID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	chat := utils.Chat{Name: &name}
+	var members []string
+	err = svc.pool.Transaction(context.Background(), func(tx pgx.Tx) error {
+		err := tx.QueryRow(context.Background(), `insert into chats(type, name) values($1, $2) returning id, type`,
+			core.ChatTypeGroup, name).Scan(&chat.ID, &chat.Type)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(context.Background(), `insert into chat_members(chat_id, user_id, role) values($1, $2, $3)`,
+			*chat.ID, ownerID, core.RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		for _, member := range group.Members {
+			if member == username {
+				continue
+			}
+			if err := addMember(tx, int(*chat.ID), ownerID, member); err != nil {
+				return err
+			}
+		}
+
+		members, err = core.ChatMembers(context.Background(), tx, int(*chat.ID))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	svc.notify(members, int(*chat.ID), username, fmt.Sprintf("%s created %s", username, name))
+
+	return &chat, nil
+}
+
+func (svc ChatService) AddMember(username string, chatID int, member string) error {
+	if err := svc.checkOwner(username, chatID); err != nil {
+		return err
+	}
+	ownerID, err := svc.userID(username)
+	if err != nil {
+		return err
+	}
+
+	var members []string
+	err = svc.pool.Transaction(context.Background(), func(tx pgx.Tx) error {
+		if err := addMember(tx, chatID, ownerID, member); err != nil {
+			return err
+		}
+
+		var err error
+		members, err = core.ChatMembers(context.Background(), tx, chatID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	svc.notify(members, chatID, username, fmt.Sprintf("%s added %s", username, member))
+	return nil
+}
+
+func (svc ChatService) RemoveMember(username string, chatID int, member string) error {
+	if member == username {
+		return svc.LeaveChat(username, chatID)
+	}
+	if err := svc.checkOwner(username, chatID); err != nil {
+		return err
+	}
+
+	memberID, err := svc.userID(member)
+	if err != nil {
+		return err
+	}
+
+	var members []string
+	err = svc.pool.Transaction(context.Background(), func(tx pgx.Tx) error {
+		tag, err := tx.Exec(context.Background(), `delete from chat_members where chat_id = $1 and user_id = $2`,
+			chatID, memberID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return core.ErrNotMember
+		}
+
+		members, err = core.ChatMembers(context.Background(), tx, chatID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// the removed member gets the notice as well
+	svc.notify(append(members, member), chatID, username, fmt.Sprintf("%s removed %s", username, member))
+	return nil
+}
+
+/*
+LeaveChat removes the user from a group. When the owner leaves, the oldest
+remaining member takes over and a group left without members is deleted with
+its messages and files.
+*/
+func (svc ChatService) LeaveChat(username string, chatID int) error {
+	userID, err := svc.userID(username)
+	if err != nil {
+		return err
+	}
+
+	var members, files []string
+	err = svc.pool.Transaction(context.Background(), func(tx pgx.Tx) error {
+		if err := checkGroup(tx, chatID); err != nil {
+			return err
+		}
+
+		var role string
+		err := tx.QueryRow(context.Background(), `delete from chat_members where chat_id = $1 and user_id = $2 returning role`,
+			chatID, userID).Scan(&role)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return core.ErrNotMember
+		}
+		if err != nil {
+			return err
+		}
+
+		members, err = core.ChatMembers(context.Background(), tx, chatID)
+		if err != nil {
+			return err
+		}
+
+		if len(members) == 0 {
+			files, err = deleteAttachments(tx, `chat_id = $1`, chatID)
+			if err != nil {
+				return err
+			}
+			// reactions, mentions, revisions and pins go with the messages
+			_, err = tx.Exec(context.Background(), `delete from messages
+				where id in (select message_id from chat_messages where chat_id = $1)`, chatID)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(context.Background(), `delete from chats where id = $1`, chatID)
+			return err
+		}
+
+		if role == core.RoleOwner {
+			_, err = tx.Exec(context.Background(), `update chat_members set role = $1
+				where chat_id = $2 and user_id = (select user_id from chat_members where chat_id = $2
+				order by joined_at, user_id limit 1)`,
+				core.RoleOwner, chatID)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// the rows are gone already, a file left behind is only wasted space
+	for _, file := range files {
+		if err := svc.storage.Delete(context.Background(), file); err != nil {
+			log.Printf("File of deleted chat %d not deleted: %v", chatID, err)
+		}
+	}
+
+	svc.notify(members, chatID, username, fmt.Sprintf("%s left", username))
+	return nil
+}
+
+func (svc ChatService) checkOwner(username string, chatID int) error {
+	if err := checkGroup(svc.pool, chatID); err != nil {
+		return err
+	}
+
+	var role string
+	err := svc.pool.QueryRow(context.Background(), `select cm.role from chat_members cm
+		inner join users u on u.id = cm.user_id where cm.chat_id = $1 and u.username = $2`, chatID, username).
+		Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return core.ErrNotMember
+	}
+	if err != nil {
+		return err
+	}
+	if role != core.RoleOwner {
+		return ErrNotOwner
+	}
+	return nil
+}
+
+// notify sends a membership notice to the given users, it is not stored
+func (svc ChatService) notify(recipients []string, chatID int, actor, body string) {
+	svc.socketManager.ForgetMembers(chatID)
+	if len(recipients) == 0 {
+		return
+	}
+	svc.socketManager.Messages <- &core.Delivery{
+		Recipients: recipients,
+		Frame: &models.Envelope{Type: models.TypeSystem, System: &models.System{
+			ChatID: chatID,
+			Actor:  actor,
+			Body:   body,
+		}},
+	}
+}
+
+// addMember adds the user to the group unless they blocked the one adding them
+func addMember(tx pgx.Tx, chatID int, adderID uint, username string) error {
+	var userID uint
+	var blocked bool
+	err := tx.QueryRow(context.Background(), `select u.id, exists(select 1 from user_blocks ub
+		where ub.blocker_id = u.id and ub.blocked_id = $2) from users u where u.username = $1`,
+		username, adderID).Scan(&userID, &blocked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return fmt.Errorf("%w: %s", core.ErrBlocked, username)
+	}
+
+	_, err = tx.Exec(context.Background(), `insert into chat_members(chat_id, user_id, role) values($1, $2, $3)
+		on conflict (chat_id, user_id) do nothing`, chatID, userID, core.RoleMember)
+	return err
+}
+
+type rowQuerier interface {
+	QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row
+}
+
+type querier interface {
+	Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error)
+}
+
+func checkGroup(q rowQuerier, chatID int) error {
+	var chatType string
+	err := q.QueryRow(context.Background(), `select type from chats where id = $1`, chatID).Scan(&chatType)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return core.ErrNotMember
+	}
+	if err != nil {
+		return err
+	}
+	if chatType != core.ChatTypeGroup {
+		return ErrNotGroup
+	}
+	return nil
+}
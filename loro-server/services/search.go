@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"server/db/utils"
+	"server/models"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+var ErrEmptyQuery = errors.New("search needs some words")
+
+// searchCursor is the last hit of a page, the next page starts right after it
+type searchCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        int       `json:"id"`
+}
+
+/*
+Search finds messages of the chats the user belongs to whose body has the
+words of the query, newest first. The query takes the syntax of web search
+engines: "exact phrase", or, and -excluded. Each hit has a snippet of the body
+with the matched words inside <mark> tags.
+*/
+func (svc ChatService) Search(username string, query models.SearchQuery) (*utils.SearchPage, error) {
+	if strings.TrimSpace(query.Q) == "" {
+		return nil, ErrEmptyQuery
+	}
+	if query.Limit <= 0 || query.Limit > maxSearchLimit {
+		query.Limit = defaultSearchLimit
+	}
+
+	userID, err := svc.userID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	var afterTime *time.Time
+	var afterID *int
+	if query.Cursor != "" {
+		after := searchCursor{}
+		if err := decodeCursor(query.Cursor, &after); err != nil {
+			return nil, err
+		}
+		afterTime, afterID = &after.CreatedAt, &after.ID
+	}
+
+	// one hit more than asked tells whether there is a next page
+	rows, err := svc.pool.Query(context.Background(), `with q as (select websearch_to_tsquery('simple', $2) as query)
+		select m.id, cm.chat_id, cm.seq, u.username, m.created_at,
+		ts_headline('simple', m.body, q.query, 'StartSel=<mark>, StopSel=</mark>, MaxWords=20, MinWords=5, MaxFragments=2')
+		from q, messages m
+		inner join chat_messages cm on cm.message_id = m.id
+		inner join chat_members me on me.chat_id = cm.chat_id and me.user_id = $1
+		left join users u on u.id = m.user_messages
+		left join hidden_messages h on h.message_id = m.id and h.user_id = $1
+		where m.body_tsv @@ q.query and m.deleted_at is null and h.message_id is null
+		and ($3::int8 is null or cm.chat_id = $3)
+		and ($4::text is null or u.username = $4)
+		and ($5::timestamptz is null or m.created_at >= $5)
+		and ($6::timestamptz is null or m.created_at < $6)
+		and ($7::timestamptz is null or (m.created_at, m.id) < ($7, $8))
+		order by m.created_at desc, m.id desc limit $9`,
+		userID, query.Q, query.ChatID, query.Sender, query.From, query.To, afterTime, afterID, query.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &utils.SearchPage{Hits: make([]utils.SearchHit, 0)}
+	for rows.Next() {
+		hit := utils.SearchHit{}
+		err := rows.Scan(&hit.MessageID, &hit.ChatID, &hit.Seq, &hit.Sender, &hit.CreatedAt, &hit.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		page.Hits = append(page.Hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Hits) > query.Limit {
+		page.Hits = page.Hits[:query.Limit]
+		last := page.Hits[query.Limit-1]
+		next := encodeCursor(searchCursor{CreatedAt: *last.CreatedAt, ID: int(*last.MessageID)})
+		page.NextCursor = &next
+	}
+	return page, nil
+}
@@ -0,0 +1,49 @@
+package storage_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"server/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalKeepsFilesUnderTheirKey(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, local.Put(ctx, "a1", strings.NewReader("hello")))
+	file, err := local.Get(ctx, "a1")
+	require.NoError(t, err)
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	require.Equal(t, "hello", string(content))
+
+	require.NoError(t, local.Delete(ctx, "a1"))
+	_, err = local.Get(ctx, "a1")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestLocalRejectsKeysLeavingItsDirectory(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+
+	for _, key := range []string{"", "../secret", "a/b", ".upload-1"} {
+		require.Error(t, local.Put(context.Background(), key, strings.NewReader("x")), key)
+	}
+}
+
+func TestNewKeyIsUnique(t *testing.T) {
+	keys := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key := storage.NewKey()
+		require.Len(t, key, 32)
+		require.False(t, keys[key], key)
+		keys[key] = true
+	}
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("stored file not found")
+
+/*
+Storage keeps the content of attachments under keys chosen by the server. The
+database only knows the key, so files can live on the local disk or in a
+bucket of an S3 compatible service.
+*/
+type Storage interface {
+	Put(ctx context.Context, key string, content io.Reader) error
+	// Get returns ErrNotFound when nothing is stored under the key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewKey returns a random key for a new file, too long to be guessed
+func NewKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -6,6 +6,7 @@ require (
 	github.com/gdamore/tcell/v2 v2.7.1
 	github.com/gorilla/websocket v1.5.3
 	github.com/rivo/tview v0.0.0-20241016194538-c5e4fb24af13
+	loro-protocol v0.0.0
 )
 
 require github.com/gdamore/encoding v1.0.0 // indirect
@@ -14,7 +15,11 @@ require (
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-runewidth v0.0.15 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
+	github.com/vmihailenco/msgpack/v5 v5.4.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
 	golang.org/x/term v0.22.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
 )
+
+replace loro-protocol => ../loro-protocol
@@ -1,9 +1,16 @@
 package internal
 
 import (
+	"fmt"
 	"log"
 	"loro-tui/internal/models"
 	"loro-tui/internal/style"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
@@ -16,8 +23,23 @@ var (
 	Pages         *tview.Pages
 	usernameTV    *tview.TextView
 	buttonNewChat *tview.Button
+	// opens the mentions of the user not read yet
+	buttonMentions *tview.Button
+	buttonSearch   *tview.Button
+	// switches the chat list between the archived chats and the others
+	buttonArchived *tview.Button
+	// lists the blocked users, choosing one unblocks them
+	buttonBlocked *tview.Button
+	// lists the contacts and the contact requests
+	buttonContacts *tview.Button
+	typingTV       *tview.TextView
+	// shows one pinned message of the open chat at a time
+	pinnedTV *tview.TextView
 )
 
+// characters of the last message shown under each chat
+const previewWidth = 24
+
 type Loro struct {
 	*log.Logger
 	*tview.Application
@@ -27,6 +49,17 @@ type Loro struct {
 	indexPage int
 }
 
+// centered places the primitive in the middle of the screen with the given size
+func centered(p tview.Primitive, width, height int) tview.Primitive {
+	return tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(p, height, 1, true).
+			AddItem(nil, 0, 1, false), width, 1, true).
+		AddItem(nil, 0, 1, false)
+}
+
 func focusInput(app *tview.Application, inputs []tview.Primitive) {
 	for index, v := range inputs {
 		if v.HasFocus() && index == len(inputs)-1 {
@@ -41,16 +74,125 @@ func focusInput(app *tview.Application, inputs []tview.Primitive) {
 }
 
 func (l *Loro) setMessagesInTable(messages []*models.Message) {
+	// a reaction row can disappear, so nothing drawn before may stay
+	chatMesssages.Clear()
+	l.rows = l.rows[:0]
 	for i := len(messages) - 1; i >= 0; i-- {
-		row := len(messages) - i - 1
+		row := len(l.rows)
 		msg := messages[i]
+		if msg.DeletedAt != nil && msg.Sender != nil {
+			newCell := tview.NewTableCell("message deleted").SetExpansion(1)
+			newCell.SetTextColor(style.LoroTheme.TertiaryTextColor)
+			if *msg.Sender == l.username {
+				newCell.SetAlign(tview.AlignRight)
+			}
+			chatMesssages.SetCell(row, 0, newCell)
+			l.rows = append(l.rows, msg)
+			continue
+		}
+		if msg.Body == nil || msg.Sender == nil {
+			continue
+		}
 		l.Logger.Printf("Message %d: %+v\nSender %s\n", row, *msg.Body, *msg.Sender)
-		newCell := tview.NewTableCell(*msg.Body).SetExpansion(1)
-		newCell.SetTextColor(style.LoroTheme.SecondaryTextColor)
+		if msg.Notice {
+			newCell := tview.NewTableCell(*msg.Body).SetExpansion(1).SetAlign(tview.AlignCenter)
+			newCell.SetTextColor(style.LoroTheme.TertiaryTextColor)
+			chatMesssages.SetCell(row, 0, newCell)
+			l.rows = append(l.rows, msg)
+			continue
+		}
+		align := tview.AlignLeft
+		if *msg.Sender == l.username {
+			align = tview.AlignRight
+		}
+		if msg.Quote != nil {
+			// the message answered goes above the reply
+			quoteCell := tview.NewTableCell(quoteText(msg.Quote)).SetExpansion(1).SetAlign(align)
+			quoteCell.SetTextColor(style.LoroTheme.TertiaryTextColor)
+			chatMesssages.SetCell(row, 0, quoteCell)
+			l.rows = append(l.rows, msg)
+			row++
+		}
+		text := *msg.Body
+		if msg.Attachment != nil {
+			text = strings.TrimSpace(attachmentText(msg.Attachment) + " " + text)
+		}
+		if l.selectedChat != nil && l.selectedChat.IsGroup() && *msg.Sender != l.username {
+			// several people talk in a group so show who wrote it
+			text = l.displayName(*msg.Sender) + ": " + text
+		}
+		if msg.EditedAt != nil {
+			text += " (edited)"
+		}
 		if *msg.Sender == l.username {
-			newCell.SetAlign(tview.AlignRight)
+			text += " " + l.statusMark(msg)
 		}
+		newCell := tview.NewTableCell(text).SetExpansion(1).SetAlign(align)
+		newCell.SetTextColor(style.LoroTheme.SecondaryTextColor)
 		chatMesssages.SetCell(row, 0, newCell)
+		l.rows = append(l.rows, msg)
+
+		if len(msg.Reactions) > 0 {
+			reactionCell := tview.NewTableCell(reactionSummary(msg.Reactions)).SetExpansion(1).SetAlign(align)
+			reactionCell.SetTextColor(style.LoroTheme.TertiaryTextColor)
+			chatMesssages.SetCell(row+1, 0, reactionCell)
+			l.rows = append(l.rows, msg)
+		}
+	}
+}
+
+// attachmentText names the file of a message and its size
+func attachmentText(attachment *models.Attachment) string {
+	size := fmt.Sprintf("%d B", attachment.Size)
+	switch {
+	case attachment.Size >= 1<<20:
+		size = fmt.Sprintf("%.1f MB", float64(attachment.Size)/(1<<20))
+	case attachment.Size >= 1<<10:
+		size = fmt.Sprintf("%.1f KB", float64(attachment.Size)/(1<<10))
+	}
+	return fmt.Sprintf("📎 %s (%s)", attachment.Filename, size)
+}
+
+// quoteText shows who wrote the message a reply answers and how it started
+func quoteText(quote *models.Quote) string {
+	if quote.Deleted || quote.Body == nil {
+		return "↱ message deleted"
+	}
+	if quote.Sender == nil {
+		return "↱ " + *quote.Body
+	}
+	return "↱ " + *quote.Sender + ": " + *quote.Body
+}
+
+// reactionSummary shows each emoji with how many reacted with it, e.g. "👍 2  ❤️ 1"
+func reactionSummary(reactions []models.ReactionCount) string {
+	parts := make([]string, 0, len(reactions))
+	for _, reaction := range reactions {
+		parts = append(parts, fmt.Sprintf("%s %d", reaction.Emoji, reaction.Count))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// statusMark tells whether a message of the user reached the server and was seen
+func (l *Loro) statusMark(msg *models.Message) string {
+	switch msg.Status {
+	case models.StatusPending:
+		return "…"
+	case models.StatusFailed:
+		return "✗"
+	}
+
+	if l.selectedChat == nil {
+		return "✓"
+	}
+	seen := l.seenBy(*l.selectedChat.ChatID, msg)
+	switch {
+	case seen == 0:
+		return "✓"
+	case l.selectedChat.IsGroup():
+		return fmt.Sprintf("✓✓%d", seen)
+	default:
+		return "✓✓"
 	}
 }
 
@@ -76,6 +218,7 @@ func createLoginPage(l *Loro) tview.Primitive {
 				l.Logger.Println("Error logging in: ", err)
 				panic(err)
 			}
+			l.Logger.Printf("Joined as session %s", l.socket().SessionID)
 			go l.AddListener()
 
 			l.username = username
@@ -100,48 +243,58 @@ func (l *Loro) fetchChats() {
 	}
 
 	l.saveChats(chats)
-
-	for i, v := range chats {
-		newCell := tview.NewTableCell(v.Username).SetExpansion(1)
-		newCell.SetTextColor(style.LoroTheme.SecondaryTextColor)
-		chatList.SetCell(i, 0, newCell)
-	}
+	l.loadPresence()
+	l.loadBlocks()
+	l.loadContacts()
+	l.renderChatList()
+	l.loadMentions()
 }
 
 func (l *Loro) getMessages(chatID int, loadChat bool) {
-	offset := 0
 	chatMsg := l.messagesMap[chatID]
-	if chatMsg != nil && loadChat {
+	if chatMsg != nil && chatMsg.fetched && loadChat {
 		// if there are messages and key enter was trigger
 		l.setMessagesInTable(chatMsg.messages)
 		chatMesssages.ScrollToEnd()
 		return
 	}
-	if chatMsg != nil {
-		// set offset to get older messages from this
-		offset = chatMsg.offset
+	var cursor *string
+	if chatMsg != nil && chatMsg.fetched {
+		if chatMsg.older == nil {
+			// the first message of the chat is loaded already
+			return
+		}
+		cursor = chatMsg.older
 	}
-	l.Logger.Printf("Fetching messages for chat %d with offset %d\n", chatID, offset)
-	msg, err := l.GetMessages(chatID, l.limit, offset)
+	l.Logger.Printf("Fetching messages for chat %d\n", chatID)
+	page, err := l.GetMessages(chatID, l.limit, cursor)
 	if err != nil {
 		l.Logger.Println("Error fetching messages: ", err)
 		panic(err)
 	}
-	l.Logger.Printf("Fetched %d messages\n", len(msg))
-	if len(msg) != 0 {
-		chatMsg = l.saveMessages(chatID, msg)
-	}
+	l.Logger.Printf("Fetched %d messages\n", len(page.Messages))
+	chatMsg = l.saveMessages(chatID, page)
 	l.Logger.Printf("Messages %+v\n", chatMsg.messages)
 	l.setMessagesInTable(chatMsg.messages)
 }
 
 func (l *Loro) eventLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case event := <-l.MessageEvents:
 			l.handleMessageEvents(event)
 		case event := <-l.ChatEvents:
 			l.handleChatEvents(event)
+		case <-ticker.C:
+			for _, chatID := range l.expireTyping() {
+				if l.selectedChat != nil && *l.selectedChat.ChatID == chatID {
+					l.renderTyping()
+					l.Application.QueueUpdateDraw(func() {})
+				}
+			}
 		}
 	}
 }
@@ -149,73 +302,571 @@ func (l *Loro) eventLoop() {
 func (l *Loro) handleMessageEvents(msg *models.MessageEvent) {
 	switch msg.Type {
 	case models.Incoming:
-		// if chatID is nil then it is a offline/online notification
-		// if chatID is not nil then is a new message
-		if msg.ChatID != nil {
-			if chat, ok := l.chatsMap[*msg.ChatID]; ok {
-				chatMsg := l.messagesMap[*chat.ChatID]
-				if chat == l.selectedChat {
-					// incoming message belongs to current chat
-					chatMsg.offset++
-					chatMsg.messages = append([]*models.Message{msg.Message}, chatMsg.messages...)
-					l.setMessagesInTable(chatMsg.messages)
-				} else {
-					if chatMsg == nil {
-						// there is no messages record
-						newChatMsg := &ChatMessages{
-							offset:   1, // storing the first incoming message
-							messages: make([]*models.Message, 0),
-						}
-						newChatMsg.messages = append(newChatMsg.messages, msg.Message)
-						l.messagesMap[*chat.ChatID] = newChatMsg
-					} else {
-						chatMsg.offset++
-						chatMsg.messages = append([]*models.Message{msg.Message}, chatMsg.messages...)
-					}
-				}
-				chatList.Clear()
-				l.setChatFirst(*chat.ChatID)
+		frame := msg.Frame
+		switch frame.Type {
+		case models.TypeMessage:
+			l.receiveMessage(&models.Message{MessagePayload: *frame.Message})
+		case models.TypeAck:
+			l.acknowledge(frame.Ack)
+		case models.TypeReceipt:
+			l.receiveReceipt(frame.Receipt)
+		case models.TypeTyping:
+			l.receiveTyping(frame.Typing)
+		case models.TypePresence:
+			l.receivePresence(frame.Presence)
+		case models.TypeResumed:
+			l.resumed(frame.Resumed)
+		case models.TypeError:
+			l.failMessage(frame.Error)
+		case models.TypeSystem:
+			l.receiveSystem(frame.System)
+		case models.TypeEdit:
+			l.editPin(frame.Edit)
+			l.renderPins()
+			if l.applyEdit(frame.Edit) {
+				l.refreshMessages(&frame.Edit.ChatID)
+			}
+		case models.TypeRetract:
+			if frame.Retract.ForEveryone {
+				l.dropPin(frame.Retract.ChatID, frame.Retract.MessageID)
+				l.renderPins()
+			}
+			if l.applyRetract(frame.Retract) {
+				l.refreshMessages(&frame.Retract.ChatID)
+			}
+		case models.TypePin:
+			l.applyPin(frame.Pin)
+			l.renderPins()
+		case models.TypeReaction:
+			if l.applyReaction(frame.Reaction) {
+				l.refreshMessages(&frame.Reaction.ChatID)
+			}
+		case models.TypeMention:
+			l.receiveMention(frame.Mention)
+		case models.TypeUnread:
+			l.receiveUnread(frame.Unread)
+		case models.TypeBlock:
+			l.receiveBlock(frame.Block)
+		case models.TypeContact:
+			// nicknames and requests are fetched again, the frame only tells something changed
+			l.loadContacts()
+			l.renderChatList()
+		}
+		l.Application.QueueUpdateDraw(func() {})
+	case models.Forward:
+		l.forward(msg.Message)
+		l.Application.QueueUpdateDraw(func() {})
+	case models.Disconnected:
+		l.Logger.Println("Connection to server lost, reconnecting")
+		l.Application.QueueUpdateDraw(func() {
+			usernameTV.SetText("Welcome " + l.username + " (reconnecting...)")
+		})
+	case models.Reconnected:
+		l.Logger.Printf("Reconnected as session %s", l.socket().SessionID)
+		// the server replays what was sent meanwhile before anything live
+		resume := &models.Envelope{Type: models.TypeResume, Resume: &models.Resume{Cursors: l.seqCursors()}}
+		if err := l.NetworkClient.Send(resume); err != nil {
+			l.Logger.Println("Error resuming: ", err)
+		}
+		// the server ignores the ones it already stored
+		for _, pending := range l.pending {
+			if err := l.NetworkClient.Send(messageFrame(pending)); err != nil {
+				l.Logger.Println("Error resending message: ", err)
+			}
+		}
+		// contacts may have come and gone while this client was away
+		l.loadPresence()
+		l.Application.QueueUpdateDraw(func() {
+			usernameTV.SetText("Welcome " + l.username)
+			l.renderChatList()
+		})
+	}
+}
 
-			} else { // new chat was created
-				username := *msg.Receiver
-				if *msg.Receiver == l.username { // current user cannot be receiver
-					username = *msg.Sender
-				}
-				l.chatsMap[*msg.ChatID] = &models.Chat{
-					ChatID:   msg.ChatID,
-					Username: username,
-				}
-				newChatMsg := &ChatMessages{
-					offset:   1, // storing the first incoming message
-					messages: make([]*models.Message, 0),
-				}
-				newChatMsg.messages = append(newChatMsg.messages, msg.Message)
-				l.messagesMap[*msg.ChatID] = newChatMsg
+// forward sends a message written in this client and shows it as pending
+func (l *Loro) forward(msg *models.Message) {
+	if msg.ClientID == nil {
+		clientID := newClientID()
+		msg.ClientID = &clientID
+	}
+	msg.Status = models.StatusPending
+	l.pending = append(l.pending, msg)
+
+	if msg.ChatID != nil {
+		chatMsg := l.pushMessage(*msg.ChatID, msg)
+		if l.selectedChat != nil && *l.selectedChat.ChatID == *msg.ChatID {
+			l.setMessagesInTable(chatMsg.messages)
+		}
+	}
+
+	// when the socket is down it is sent again after reconnecting
+	if err := l.NetworkClient.Send(messageFrame(msg)); err != nil {
+		l.Logger.Println("Error sending message: ", err)
+	}
+}
+
+func messageFrame(msg *models.Message) *models.Envelope {
+	payload := msg.MessagePayload
+	return &models.Envelope{Type: models.TypeMessage, Message: &payload}
+}
+
+func (l *Loro) acknowledge(ack *models.Ack) {
+	msg := l.takePending(ack.ClientID)
+	if msg == nil {
+		return
+	}
+	msg.ID = &ack.ID
+	msg.ChatID = &ack.ChatID
+	msg.CreatedAt = &ack.CreatedAt
+	if msg.Seq == nil {
+		msg.Seq = &ack.Seq
+		l.noteSeq(ack.ChatID, msg.Seq)
+	}
+	msg.Status = models.StatusSent
+	l.refreshMessages(msg.ChatID)
+}
+
+func (l *Loro) failMessage(frameErr *models.FrameError) {
+	l.Logger.Printf("Server error %s: %s", frameErr.Code, frameErr.Message)
+	msg := l.takePending(frameErr.ClientID)
+	if msg == nil {
+		return
+	}
+	msg.Status = models.StatusFailed
+	msg.Error = frameErr
+	l.refreshMessages(msg.ChatID)
+}
+
+// refreshMessages draws the messages again when the chat is open
+func (l *Loro) refreshMessages(chatID *int) {
+	if chatID == nil || l.selectedChat == nil || *l.selectedChat.ChatID != *chatID {
+		return
+	}
+	if chatMsg := l.messagesMap[*chatID]; chatMsg != nil {
+		l.setMessagesInTable(chatMsg.messages)
+	}
+}
+
+func (l *Loro) receiveMessage(msg *models.Message) {
+	if msg.ChatID == nil {
+		return
+	}
+
+	chat, ok := l.chatsMap[*msg.ChatID]
+	if !ok {
+		if msg.Receiver == nil { // added to a group
+			chats, err := l.GetChats()
+			if err != nil {
+				l.Logger.Println("Error fetching chats: ", err)
+				return
+			}
+			l.saveChats(chats)
+		} else { // new chat was created
+			username := *msg.Receiver
+			if *msg.Receiver == l.username { // current user cannot be receiver
+				username = *msg.Sender
+			}
+			l.chatsMap[*msg.ChatID] = &models.Chat{
+				ChatID:   msg.ChatID,
+				Username: username,
+				Type:     models.DirectChat,
+			}
+			l.chatList = append([]int{*msg.ChatID}, l.chatList...)
+			if msg.Sender != nil && *msg.Sender == l.username {
+				// the chat was opened from this client
 				l.selectedChat = l.chatsMap[*msg.ChatID]
-				l.chatList = append([]int{*msg.ChatID}, l.chatList...)
 				chatMesssages.Clear()
-				l.setMessagesInTable(newChatMsg.messages)
 				l.SetFocus(chatInput)
 			}
-			for i, chatID := range l.chatList {
-				username := l.chatsMap[chatID].Username
-				newCell := tview.NewTableCell(username).SetExpansion(1)
-				newCell.SetTextColor(style.LoroTheme.SecondaryTextColor)
-				chatList.SetCell(i, 0, newCell)
-				if l.selectedChat != nil && chatID == *l.selectedChat.ChatID {
-					chatList.Select(i, 0)
-				}
+		}
+		if chat, ok = l.chatsMap[*msg.ChatID]; !ok {
+			return
+		}
+	}
+
+	if msg.Sender != nil {
+		// the message is what they were typing
+		l.setTyping(*msg.ChatID, *msg.Sender, false)
+	}
+	chatMsg := l.pushMessage(*msg.ChatID, msg)
+	chat.SetLastMessage(msg)
+	if !chat.Muted() {
+		// the server brings the chat back to the list as well
+		chat.Archived, chat.Hidden = false, false
+	}
+	if chat == l.selectedChat {
+		// incoming message belongs to current chat
+		l.setMessagesInTable(chatMsg.messages)
+		l.markRead(*chat.ChatID)
+		l.renderTyping()
+	}
+	l.setChatFirst(*chat.ChatID)
+	l.renderChatList()
+}
+
+func (l *Loro) receiveReceipt(receipt *models.Receipt) {
+	l.setReadCursor(receipt.ChatID, receipt.Username, receipt.MessageID)
+	if receipt.Username == l.username {
+		// read from another session of this user
+		l.lastRead[receipt.ChatID] = max(l.lastRead[receipt.ChatID], receipt.MessageID)
+	}
+	l.refreshMessages(&receipt.ChatID)
+}
+
+// receiveSystem shows a notice about the chat such as a member joining or leaving
+func (l *Loro) receiveSystem(system *models.System) {
+	notice := &models.Message{
+		MessagePayload: models.MessagePayload{ChatID: &system.ChatID, Sender: &system.Actor, Body: &system.Body},
+		Notice:         true,
+	}
+	l.receiveMessage(notice)
+}
+
+// loadReceipts fetches where every member of the chat stopped reading
+func (l *Loro) loadReceipts(chatID int) {
+	cursors, err := l.GetReceipts(chatID)
+	if err != nil {
+		l.Logger.Println("Error fetching receipts: ", err)
+		return
+	}
+	for _, cursor := range cursors {
+		if cursor.MessageID != nil {
+			l.setReadCursor(chatID, cursor.Username, *cursor.MessageID)
+		}
+	}
+}
+
+// markRead tells the server the user has seen the latest message of the chat
+func (l *Loro) markRead(chatID int) {
+	if chat := l.chatsMap[chatID]; chat != nil && chat.Unread > 0 {
+		chat.Unread = 0
+		l.renderChatList()
+	}
+	messageID := l.newestFromOthers(chatID, l.username)
+	if messageID <= l.lastRead[chatID] {
+		return
+	}
+	l.lastRead[chatID] = messageID
+
+	read := &models.Envelope{Type: models.TypeRead, Read: &models.Read{ChatID: chatID, MessageID: messageID}}
+	if err := l.NetworkClient.Send(read); err != nil {
+		l.Logger.Println("Error sending read receipt: ", err)
+	}
+	// the server reads the mentions up to the message as well
+	l.dropMentions(chatID, messageID)
+	l.renderMentions()
+}
+
+// loadPins fetches the pinned messages of the chat being opened
+func (l *Loro) loadPins(chatID int) {
+	pins, err := l.GetPins(chatID)
+	if err != nil {
+		l.Logger.Println("Error fetching pins: ", err)
+		return
+	}
+	l.pins[chatID] = pins
+	l.pinShown = 0
+	l.renderPins()
+}
+
+// renderPins shows the pin of the open chat the bar is at
+func (l *Loro) renderPins() {
+	if l.selectedChat == nil || len(l.pins[*l.selectedChat.ChatID]) == 0 {
+		pinnedTV.SetText("")
+		return
+	}
+	pins := l.pins[*l.selectedChat.ChatID]
+	if l.pinShown >= len(pins) {
+		l.pinShown = 0
+	}
+	pin := pins[l.pinShown]
+	text := "📌"
+	if len(pins) > 1 {
+		text += fmt.Sprintf(" %d/%d", l.pinShown+1, len(pins))
+	}
+	if pin.Sender != nil {
+		text += " " + l.displayName(*pin.Sender) + ":"
+	}
+	if pin.Body != nil {
+		text += " " + *pin.Body
+	}
+	pinnedTV.SetText(text)
+}
+
+// jumpToMessage selects the message in the open chat, loading older messages until it is found
+func (l *Loro) jumpToMessage(chatID, messageID int) {
+	if l.selectedChat == nil || *l.selectedChat.ChatID != chatID {
+		return
+	}
+	row := l.rowOf(messageID)
+	for row < 0 {
+		loaded := len(l.rows)
+		l.getMessages(chatID, false)
+		if len(l.rows) == loaded {
+			// the first message of the chat is loaded already
+			return
+		}
+		row = l.rowOf(messageID)
+	}
+	chatMesssages.Select(row, 0)
+	l.Application.SetFocus(chatMesssages)
+}
+
+// togglePin pins the message to the top of the open chat or unpins it
+func (l *Loro) togglePin(msg *models.Message) {
+	if msg == nil || msg.ID == nil || msg.Notice || msg.DeletedAt != nil || l.selectedChat == nil {
+		return
+	}
+	chatID := *l.selectedChat.ChatID
+	pin := &models.Pin{ChatID: chatID, MessageID: *msg.ID, Pinned: !l.isPinned(chatID, *msg.ID)}
+	if err := l.NetworkClient.Send(&models.Envelope{Type: models.TypePin, Pin: pin}); err != nil {
+		l.Logger.Println("Error sending pin: ", err)
+	}
+}
+
+// loadMentions fetches the mentions of the user not read yet
+func (l *Loro) loadMentions() {
+	mentions, err := l.GetMentions()
+	if err != nil {
+		l.Logger.Println("Error fetching mentions: ", err)
+		return
+	}
+	l.mentions = mentions
+	l.renderMentions()
+}
+
+func (l *Loro) receiveMention(mention *models.Mention) {
+	// the message is read already when its chat is open
+	if l.selectedChat != nil && *l.selectedChat.ChatID == mention.ChatID {
+		return
+	}
+	if l.addMention(mention) {
+		l.renderMentions()
+	}
+}
+
+// renderMentions shows how many mentions wait on their button
+func (l *Loro) renderMentions() {
+	if len(l.mentions) == 0 {
+		buttonMentions.SetLabel("Mentions")
+		return
+	}
+	buttonMentions.SetLabel(fmt.Sprintf("Mentions (%d)", len(l.mentions)))
+}
+
+// showMentions lists the mentions waiting, choosing one opens its chat
+func (l *Loro) showMentions() {
+	list := tview.NewList().ShowSecondaryText(true)
+	list.SetBorder(true).SetTitle(" mentions ")
+	for _, mention := range l.mentions {
+		chatID := mention.ChatID
+		title := fmt.Sprintf("chat %d", chatID)
+		if chat := l.chatsMap[chatID]; chat != nil {
+			title = l.chatTitle(chat)
+		}
+		messageID := mention.MessageID
+		list.AddItem(title, l.displayName(mention.Sender)+": "+mention.Body, 0, func() {
+			Pages.RemovePage("modal")
+			l.openChat(chatID, messageID)
+		})
+	}
+	list.SetDoneFunc(func() {
+		Pages.RemovePage("modal")
+		l.Application.SetFocus(buttonMentions)
+	})
+	if len(l.mentions) == 0 {
+		list.AddItem("No mentions", "", 0, nil)
+	}
+
+	Pages.AddPage("modal", list, true, true)
+	l.Application.SetFocus(list)
+}
+
+// openChat selects the chat in the list and loads it as if chosen there, then selects the message if any
+func (l *Loro) openChat(chatID, messageID int) {
+	for i, id := range l.shown {
+		if id == chatID {
+			chatList.Select(2*i, 0)
+		}
+	}
+	chatMesssages.Clear()
+	l.selectedChat = l.chatsMap[chatID]
+	l.Application.SetFocus(chatMesssages)
+	l.ChatEvents <- &models.ChatEvent{Type: models.LoadChat, ChatID: chatID, MessageID: messageID}
+}
+
+func (l *Loro) receiveTyping(typing *models.Typing) {
+	if typing.Username == l.username {
+		return
+	}
+	l.setTyping(typing.ChatID, typing.Username, typing.State == models.TypingStart)
+	if l.selectedChat != nil && *l.selectedChat.ChatID == typing.ChatID {
+		l.renderTyping()
+	}
+}
+
+// renderTyping shows who is typing in the open chat above the input
+func (l *Loro) renderTyping() {
+	if l.selectedChat == nil {
+		typingTV.SetText("")
+		return
+	}
+
+	usernames := l.typingIn(*l.selectedChat.ChatID)
+	for i, username := range usernames {
+		usernames[i] = l.displayName(username)
+	}
+	switch len(usernames) {
+	case 0:
+		typingTV.SetText("")
+	case 1:
+		typingTV.SetText(usernames[0] + " is typing…")
+	default:
+		typingTV.SetText(strings.Join(usernames, ", ") + " are typing…")
+	}
+}
+
+// resumed reloads the chats that missed too much to be replayed
+func (l *Loro) resumed(resumed *models.Resumed) {
+	for _, chatID := range resumed.Truncated {
+		l.Logger.Printf("Chat %d missed too many messages, reloading", chatID)
+		delete(l.messagesMap, chatID)
+		if l.selectedChat != nil && *l.selectedChat.ChatID == chatID {
+			chatMesssages.Clear()
+			l.getMessages(chatID, true)
+			l.markRead(chatID)
+		}
+	}
+}
+
+// loadPresence fetches whether the peers of direct chats are online
+func (l *Loro) loadPresence() {
+	peers := l.peers()
+	if len(peers) == 0 {
+		return
+	}
+	presence, err := l.GetPresence(peers)
+	if err != nil {
+		l.Logger.Println("Error fetching presence: ", err)
+		return
+	}
+	for _, p := range presence {
+		l.presence[p.Username] = p
+	}
+}
+
+func (l *Loro) receivePresence(presence *models.Presence) {
+	l.presence[presence.Username] = presence
+	l.renderChatList()
+}
+
+// lastSeen describes how long ago a contact was last seen
+func lastSeen(t time.Time) string {
+	since := time.Since(t)
+	switch {
+	case since < time.Minute:
+		return "just now"
+	case since < time.Hour:
+		return fmt.Sprintf("%dm ago", int(since.Minutes()))
+	case since < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(since.Hours()))
+	default:
+		return t.Format("Jan 2")
+	}
+}
+
+// preview shortens the body to one line of at most width characters
+func preview(body string, width int) string {
+	runes := []rune(strings.Join(strings.Fields(body), " "))
+	if len(runes) <= width {
+		return string(runes)
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+/*
+renderChatList draws two rows for each chat: the title with the unread count
+and the time of the last message, then a preview of it with the presence of
+direct chats. Only the title rows can be selected.
+*/
+func (l *Loro) renderChatList() {
+	chatList.Clear()
+	l.shown = l.shown[:0]
+	for _, chatID := range l.chatList {
+		chat := l.chatsMap[chatID]
+		if !l.listed(chat) {
+			continue
+		}
+		i := len(l.shown)
+		l.shown = append(l.shown, chatID)
+		title := l.chatTitle(chat)
+		seen := ""
+		if !chat.IsGroup() {
+			// a dot tells whether the other user is online
+			title = "○ " + title
+			if p := l.presence[chat.Username]; p != nil && p.Online {
+				title = "● " + l.chatTitle(chat)
+				seen = "online"
+			} else if p != nil && p.LastSeenAt != nil {
+				seen = lastSeen(*p.LastSeenAt)
+			}
+			if l.blocked[chat.Username] {
+				seen = "blocked"
 			}
 		}
-		l.Application.QueueUpdateDraw(func() {})
-	case models.Forward:
-		if err := l.socketClient.Send(msg.Message); err != nil {
-			l.Logger.Println("Error sending message: ", err)
-			panic("ERROR SENDING MESSAGE")
+		sent := ""
+		if chat.LastMessageTime != nil {
+			sent = lastSeen(*chat.LastMessageTime)
+		}
+		last := ""
+		if chat.LastMessage != nil {
+			last = preview(*chat.LastMessage, previewWidth)
+		}
+
+		newCell := tview.NewTableCell(title).SetExpansion(1)
+		newCell.SetTextColor(style.LoroTheme.SecondaryTextColor)
+		sentCell := tview.NewTableCell(sent).SetAlign(tview.AlignRight)
+		sentCell.SetTextColor(style.LoroTheme.TertiaryTextColor)
+		if chat.Muted() {
+			title += " 🔕"
+			newCell.SetText(title)
+		}
+		if chat.Unread > 0 {
+			newCell.SetText(fmt.Sprintf("%s (%d)", title, chat.Unread))
+			// muted chats count quietly
+			if !chat.Muted() {
+				newCell.SetAttributes(tcell.AttrBold)
+				sentCell.SetAttributes(tcell.AttrBold)
+			}
+		}
+		chatList.SetCell(2*i, 0, newCell)
+		chatList.SetCell(2*i, 1, sentCell)
+
+		lastCell := tview.NewTableCell(last).SetSelectable(false)
+		lastCell.SetTextColor(style.LoroTheme.TertiaryTextColor)
+		chatList.SetCell(2*i+1, 0, lastCell)
+		seenCell := tview.NewTableCell(seen).SetAlign(tview.AlignRight).SetSelectable(false)
+		seenCell.SetTextColor(style.LoroTheme.TertiaryTextColor)
+		chatList.SetCell(2*i+1, 1, seenCell)
+		if l.selectedChat != nil && chatID == *l.selectedChat.ChatID {
+			chatList.Select(2*i, 0)
 		}
 	}
 }
 
+// receiveUnread keeps the count of a chat, the open one is read as it comes
+func (l *Loro) receiveUnread(unread *models.Unread) {
+	chat, ok := l.chatsMap[unread.ChatID]
+	if !ok {
+		return
+	}
+	chat.Unread = unread.Count
+	if chat == l.selectedChat {
+		chat.Unread = 0
+	}
+	l.renderChatList()
+}
+
 func (l *Loro) handleChatEvents(event *models.ChatEvent) {
 	switch event.Type {
 	case models.FetchChats:
@@ -225,8 +876,34 @@ func (l *Loro) handleChatEvents(event *models.ChatEvent) {
 		// when you scroll up then fetch older messages
 		l.getMessages(event.ChatID, false)
 		l.Application.QueueUpdateDraw(func() {})
+	case models.JumpToMessage:
+		l.jumpToMessage(event.ChatID, event.MessageID)
+		l.Application.QueueUpdateDraw(func() {})
 	case models.LoadChat:
+		l.loadReceipts(event.ChatID)
+		l.loadPins(event.ChatID)
+		l.getMessages(event.ChatID, true)
+		l.markRead(event.ChatID)
+		l.renderTyping()
+		l.Application.SetFocus(chatInput)
+		if event.MessageID != 0 {
+			l.jumpToMessage(event.ChatID, event.MessageID)
+		}
+		l.Application.QueueUpdateDraw(func() {})
+	case models.NewChat:
+		// a group was created from this client, refresh the list and open it
+		l.fetchChats()
+		l.selectedChat = l.chatsMap[event.ChatID]
+		for i, chatID := range l.shown {
+			if chatID == event.ChatID {
+				chatList.Select(2*i, 0)
+			}
+		}
+		chatMesssages.Clear()
+		l.loadReceipts(event.ChatID)
+		l.loadPins(event.ChatID)
 		l.getMessages(event.ChatID, true)
+		l.renderTyping()
 		l.Application.SetFocus(chatInput)
 		l.Application.QueueUpdateDraw(func() {})
 	}
@@ -244,31 +921,138 @@ func createChatPage(l *Loro) tview.Primitive {
 		chatInput.SetFieldBackgroundColor(style.LoroTheme.ContrastBackgroundColor)
 	})
 	chatMesssages = tview.NewTable()
+	typingTV = tview.NewTextView()
+	typingTV.SetTextColor(style.LoroTheme.TertiaryTextColor)
+	pinnedTV = tview.NewTextView()
+	pinnedTV.SetTextColor(style.LoroTheme.SecondaryTextColor)
 	buttonNewChat = tview.NewButton("New Chat")
+	buttonMentions = tview.NewButton("Mentions")
+	buttonSearch = tview.NewButton("Search")
+	buttonArchived = tview.NewButton("Archived")
+	buttonBlocked = tview.NewButton("Blocked")
+	buttonContacts = tview.NewButton("Contacts")
 	inputs := []tview.Primitive{
 		chatList,
 		chatInput,
 		chatMesssages,
+		pinnedTV,
 		buttonNewChat,
+		buttonMentions,
+		buttonSearch,
+		buttonArchived,
+		buttonBlocked,
+		buttonContacts,
+	}
+
+	// typing start is repeated while the user types, receivers expire it otherwise
+	var typingChat *int
+	var typingSent time.Time
+	sendTyping := func(chatID *int, state string) {
+		frame := &models.Envelope{Type: models.TypeTyping, Typing: &models.Typing{ChatID: *chatID, State: state}}
+		if err := l.NetworkClient.Send(frame); err != nil {
+			l.Logger.Println("Error sending typing: ", err)
+		}
+	}
+	chatInput.SetChangedFunc(func(text string) {
+		if typingChat != nil && (text == "" || l.selectedChat == nil || *typingChat != *l.selectedChat.ChatID) {
+			sendTyping(typingChat, models.TypingStop)
+			typingChat = nil
+		}
+		if text == "" || l.selectedChat == nil {
+			return
+		}
+		if typingChat == nil || time.Since(typingSent) > typingRefresh {
+			typingChat = l.selectedChat.ChatID
+			typingSent = time.Now()
+			sendTyping(typingChat, models.TypingStart)
+		}
+	})
+
+	// up on an empty input edits the last message of the user, escape gives up
+	var editing *models.Message
+	stopEditing := func() {
+		editing = nil
+		chatInput.SetLabel("")
+		chatInput.SetText("")
+	}
+
+	// enter on a message starts a reply to it, escape gives up
+	var replying *models.Message
+	stopReplying := func() {
+		replying = nil
+		chatInput.SetLabel("")
 	}
 
 	chatInput.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		switch event.Key() {
+		case tcell.KeyUp:
+			if editing != nil || l.selectedChat == nil || chatInput.GetText() != "" {
+				return event
+			}
+			if editing = l.lastSentBy(*l.selectedChat.ChatID, l.username); editing != nil {
+				chatInput.SetLabel("edit: ")
+				chatInput.SetText(*editing.Body)
+			}
+			return nil
+		case tcell.KeyEscape:
+			if editing != nil {
+				stopEditing()
+				return nil
+			}
+			if replying != nil {
+				stopReplying()
+				return nil
+			}
 		case tcell.KeyEnter:
-			if l.selectedChat != nil {
+			if editing != nil {
 				input := chatInput.GetText()
-				if len(input) > 0 {
-					message := &models.Message{
-						Body:     &input,
-						Sender:   &l.username,
-						ChatID:   l.selectedChat.ChatID,
-						Receiver: &l.selectedChat.Username,
+				if input != "" && input != *editing.Body {
+					edit := &models.Envelope{Type: models.TypeEdit, Edit: &models.Edit{
+						ChatID:    *editing.ChatID,
+						MessageID: *editing.ID,
+						Body:      input,
+					}}
+					if err := l.NetworkClient.Send(edit); err != nil {
+						l.Logger.Println("Error sending edit: ", err)
 					}
-					l.MessageEvents <- &models.MessageEvent{Type: models.Forward, Message: message}
-					chatInput.SetText("")
 				}
+				stopEditing()
+				return nil
 			}
-
+			if l.selectedChat != nil {
+				input := chatInput.GetText()
+				var attachment *models.Attachment
+				if path, ok := strings.CutPrefix(input, "/send "); ok {
+					// the file goes up first and the message only carries its id
+					uploaded, err := l.Upload(*l.selectedChat.ChatID, strings.TrimSpace(path))
+					if err != nil {
+						l.Logger.Println("Error uploading file: ", err)
+						l.notify("Could not send the file: " + err.Error())
+						return nil
+					}
+					attachment, input = uploaded, ""
+				}
+				if len(input) > 0 || attachment != nil {
+					message := &models.Message{MessagePayload: models.MessagePayload{
+						Body:       &input,
+						Sender:     &l.username,
+						ChatID:     l.selectedChat.ChatID,
+						Attachment: attachment,
+					}}
+					if !l.selectedChat.IsGroup() {
+						message.Receiver = &l.selectedChat.Username
+					}
+					if replying != nil {
+						// quoted right away, the server sends its own quote to the others
+						message.ReplyTo = replying.ID
+						message.Quote = &models.Quote{ID: *replying.ID, Sender: replying.Sender, Body: replying.Body}
+						stopReplying()
+					}
+					l.MessageEvents <- &models.MessageEvent{Type: models.Forward, Message: message}
+					chatInput.SetText("")
+				}
+			}
+
 		case tcell.KeyTab:
 			focusInput(l.Application, inputs)
 		}
@@ -277,9 +1061,43 @@ func createChatPage(l *Loro) tview.Primitive {
 
 	chatMesssages.SetBorder(true)
 	chatMesssages.SetBorderColor(style.LoroTheme.MoreContrastBackgroundColor)
+	// a selected message is answered with Enter, deleted with Delete or d, reacted to with r,
+	// pinned with p, its file saved with s and its replies are shown with t
+	chatMesssages.SetSelectable(true, false)
+	chatMesssages.SetSelectedStyle(style.CellSelectedtyle)
 	chatMesssages.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		row, _ := chatMesssages.GetSelection()
+		if event.Rune() == 't' {
+			l.showThread(l.messageAt(row))
+			return nil
+		}
+		if event.Rune() == 'p' {
+			l.togglePin(l.messageAt(row))
+			return nil
+		}
+		if event.Rune() == 's' {
+			l.saveAttachment(l.messageAt(row))
+			return nil
+		}
+		if event.Key() == tcell.KeyDelete || event.Rune() == 'd' {
+			l.confirmDelete(l.messageAt(row))
+			return nil
+		}
+		if event.Rune() == 'r' {
+			l.chooseReaction(l.messageAt(row))
+			return nil
+		}
 		switch event.Key() {
+		case tcell.KeyEnter:
+			msg := l.messageAt(row)
+			if msg == nil || msg.ID == nil || msg.Notice || msg.DeletedAt != nil || msg.Sender == nil {
+				return nil
+			}
+			stopEditing()
+			replying = msg
+			chatInput.SetLabel("reply to " + l.displayName(*msg.Sender) + ": ")
+			l.Application.SetFocus(chatInput)
+			return nil
 		case tcell.KeyUp:
 			if row == 0 && l.selectedChat != nil {
 				l.ChatEvents <- &models.ChatEvent{Type: models.GetMessages, ChatID: *l.selectedChat.ChatID}
@@ -295,14 +1113,40 @@ func createChatPage(l *Loro) tview.Primitive {
 	l.Application.SetFocus(chatList)
 	chatList.SetBorder(true)
 	chatList.SetBorderColor(style.LoroTheme.MoreContrastBackgroundColor)
-	chatList.SetSelectable(true, true)
+	// rows are selected whole, the second column only shows times and presence
+	chatList.SetSelectable(true, false)
 	chatList.SetSelectedStyle(style.CellSelectedtyle)
+	// a selected chat is archived or brought back with a, hidden with h and muted with m,
+	// the other user of a direct chat is blocked or unblocked with b
 	chatList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		row, _ := chatList.GetSelection()
+		var chat *models.Chat
+		if row/2 < len(l.shown) {
+			chat = l.chatsMap[l.shown[row/2]]
+		}
+		switch event.Rune() {
+		case 'a':
+			l.toggleArchive(chat)
+			return nil
+		case 'h':
+			l.confirmHide(chat)
+			return nil
+		case 'm':
+			l.chooseMute(chat)
+			return nil
+		case 'b':
+			if chat != nil && !chat.IsGroup() {
+				l.confirmBlock(chat.Username, chatList)
+			}
+			return nil
+		}
 		switch event.Key() {
 		case tcell.KeyEnter:
+			if chat == nil {
+				return nil
+			}
 			chatMesssages.Clear()
-			row, _ := chatList.GetSelection()
-			chatID := l.chatList[row]
+			chatID := *chat.ChatID
 			l.selectedChat = l.chatsMap[chatID]
 			l.ChatEvents <- &models.ChatEvent{Type: models.LoadChat, ChatID: chatID}
 		case tcell.KeyTab:
@@ -316,15 +1160,7 @@ func createChatPage(l *Loro) tview.Primitive {
 
 	mainLayout := tview.NewFlex().SetDirection(tview.FlexRow)
 
-	modal := func(p tview.Primitive, width, height int) tview.Primitive {
-		return tview.NewFlex().
-			AddItem(nil, 0, 1, false).
-			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
-				AddItem(nil, 0, 1, false).
-				AddItem(p, height, 1, true).
-				AddItem(nil, 0, 1, false), width, 1, true).
-			AddItem(nil, 0, 1, false)
-	}
+	modal := centered
 
 	form := tview.NewForm()
 	form.SetLabelColor(style.LoroTheme.SecondaryTextColor)
@@ -333,17 +1169,50 @@ func createChatPage(l *Loro) tview.Primitive {
 	form.SetButtonStyle(style.ButtonStyle)
 	form.SetButtonActivatedStyle(style.BtnActivatedStyle)
 	form.AddInputField("To", "", 30, nil, nil).
+		AddInputField("Group name", "", 30, nil, nil).
 		AddInputField("Message", "", 30, nil, nil).
 		AddButton("Send", func() {
-			toUsername := form.GetFormItem(0).(*tview.InputField).GetText()
-			body := form.GetFormItem(1).(*tview.InputField).GetText()
-			message := &models.Message{
-				Receiver: &toUsername,
-				Sender:   &l.username,
-				Body:     &body,
+			to := form.GetFormItem(0).(*tview.InputField).GetText()
+			groupName := strings.TrimSpace(form.GetFormItem(1).(*tview.InputField).GetText())
+			body := form.GetFormItem(2).(*tview.InputField).GetText()
+
+			usernames := make([]string, 0)
+			for _, username := range strings.Split(to, ",") {
+				if username = strings.TrimSpace(username); username != "" {
+					usernames = append(usernames, username)
+				}
 			}
 
-			l.MessageEvents <- &models.MessageEvent{Type: models.Forward, Message: message}
+			if groupName == "" && len(usernames) == 1 {
+				message := &models.Message{MessagePayload: models.MessagePayload{
+					Receiver: &usernames[0],
+					Sender:   &l.username,
+					Body:     &body,
+				}}
+
+				l.MessageEvents <- &models.MessageEvent{Type: models.Forward, Message: message}
+			} else if groupName != "" || len(usernames) > 1 {
+				// several recipients or a name makes it a group
+				if groupName == "" {
+					groupName = strings.Join(usernames, ", ")
+				}
+				chat, err := l.CreateGroup(models.GroupRequest{Name: groupName, Members: usernames})
+				if err != nil {
+					l.Logger.Println("Error creating group: ", err)
+					return
+				}
+				l.ChatEvents <- &models.ChatEvent{Type: models.NewChat, ChatID: *chat.ChatID}
+				if body != "" {
+					message := &models.Message{MessagePayload: models.MessagePayload{
+						Sender: &l.username,
+						Body:   &body,
+						ChatID: chat.ChatID,
+					}}
+					l.MessageEvents <- &models.MessageEvent{Type: models.Forward, Message: message}
+				}
+			} else {
+				return
+			}
 
 			Pages.RemovePage("modal")
 			// l.Application.SetFocus(chatList)
@@ -354,7 +1223,101 @@ func createChatPage(l *Loro) tview.Primitive {
 	buttonNewChat.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		switch event.Key() {
 		case tcell.KeyEnter:
-			Pages.AddPage("modal", modal(form, 10, 10), true, true)
+			Pages.AddPage("modal", modal(form, 40, 11), true, true)
+		case tcell.KeyTab:
+			focusInput(l.Application, inputs)
+		}
+		return event
+	})
+
+	// enter jumps to the pin shown and moves the bar on to the next one
+	pinnedTV.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEnter:
+			if l.selectedChat == nil {
+				return nil
+			}
+			chatID := *l.selectedChat.ChatID
+			pins := l.pins[chatID]
+			if len(pins) == 0 {
+				return nil
+			}
+			pin := pins[l.pinShown%len(pins)]
+			l.pinShown = (l.pinShown + 1) % len(pins)
+			l.renderPins()
+			l.ChatEvents <- &models.ChatEvent{Type: models.JumpToMessage, ChatID: chatID, MessageID: pin.MessageID}
+			return nil
+		case tcell.KeyTab:
+			focusInput(l.Application, inputs)
+		}
+		return event
+	})
+
+	buttonMentions.SetStyle(style.ButtonStyle)
+	buttonMentions.SetActivatedStyle(style.BtnActivatedStyle)
+	buttonMentions.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEnter:
+			l.showMentions()
+			return nil
+		case tcell.KeyTab:
+			focusInput(l.Application, inputs)
+		}
+		return event
+	})
+
+	buttonSearch.SetStyle(style.ButtonStyle)
+	buttonSearch.SetActivatedStyle(style.BtnActivatedStyle)
+	buttonSearch.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEnter:
+			l.showSearch()
+			return nil
+		case tcell.KeyTab:
+			focusInput(l.Application, inputs)
+		}
+		return event
+	})
+
+	buttonArchived.SetStyle(style.ButtonStyle)
+	buttonArchived.SetActivatedStyle(style.BtnActivatedStyle)
+	buttonArchived.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEnter:
+			l.archivedView = !l.archivedView
+			if l.archivedView {
+				buttonArchived.SetLabel("Inbox")
+			} else {
+				buttonArchived.SetLabel("Archived")
+			}
+			l.renderChatList()
+			return nil
+		case tcell.KeyTab:
+			focusInput(l.Application, inputs)
+		}
+		return event
+	})
+
+	buttonBlocked.SetStyle(style.ButtonStyle)
+	buttonBlocked.SetActivatedStyle(style.BtnActivatedStyle)
+	buttonBlocked.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEnter:
+			l.showBlocked()
+			return nil
+		case tcell.KeyTab:
+			focusInput(l.Application, inputs)
+		}
+		return event
+	})
+
+	buttonContacts.SetStyle(style.ButtonStyle)
+	buttonContacts.SetActivatedStyle(style.BtnActivatedStyle)
+	buttonContacts.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEnter:
+			l.showContacts()
+			return nil
 		case tcell.KeyTab:
 			focusInput(l.Application, inputs)
 		}
@@ -363,13 +1326,20 @@ func createChatPage(l *Loro) tview.Primitive {
 
 	menuTitle := tview.NewFlex().SetDirection(tview.FlexColumn).
 		AddItem(usernameTV, 0, 1, false).
-		AddItem(buttonNewChat, 0, 1, false)
+		AddItem(buttonNewChat, 0, 1, false).
+		AddItem(buttonMentions, 0, 1, false).
+		AddItem(buttonSearch, 0, 1, false).
+		AddItem(buttonArchived, 0, 1, false).
+		AddItem(buttonBlocked, 0, 1, false).
+		AddItem(buttonContacts, 0, 1, false)
 
 	chatLayout := tview.NewFlex().SetDirection(tview.FlexColumn).
 		AddItem(chatList,
 			0, 1, false).
 		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(pinnedTV, 1, 1, false).
 			AddItem(chatMesssages, 0, 1, false).
+			AddItem(typingTV, 1, 1, false).
 			AddItem(chatInput, 1, 1, false),
 			0, 4, false)
 	chatLayout.SetBorder(false)
@@ -382,9 +1352,9 @@ func createChatPage(l *Loro) tview.Primitive {
 	return mainLayout
 }
 
-func NewLoro(url string, log *log.Logger) (*Loro, error) {
+func NewLoro(url, codec string, log *log.Logger) (*Loro, error) {
 
-	networkClient, err := NewNetworkClient(url)
+	networkClient, err := NewNetworkClient(url, codec)
 	if err != nil {
 		log.Println("Error creating network client: ", err)
 		return nil, err
@@ -409,3 +1379,602 @@ func NewLoro(url string, log *log.Logger) (*Loro, error) {
 
 	return loro, nil
 }
+
+// confirmDelete asks whether to delete the message for the user or for everyone
+func (l *Loro) confirmDelete(msg *models.Message) {
+	if msg == nil || msg.ID == nil || msg.Notice || msg.DeletedAt != nil || l.selectedChat == nil {
+		return
+	}
+	// messages loaded from the api do not carry their chat
+	chatID := *l.selectedChat.ChatID
+	buttons := []string{"For me"}
+	if msg.Sender != nil && *msg.Sender == l.username {
+		buttons = append(buttons, "For everyone")
+	}
+	buttons = append(buttons, "Cancel")
+
+	modal := tview.NewModal().
+		SetText("Delete message?").
+		AddButtons(buttons).
+		SetDoneFunc(func(_ int, label string) {
+			Pages.RemovePage("modal")
+			l.Application.SetFocus(chatMesssages)
+			if label != "For me" && label != "For everyone" {
+				return
+			}
+			retract := &models.Retract{ChatID: chatID, MessageID: *msg.ID, ForEveryone: label == "For everyone"}
+			if err := l.NetworkClient.Send(&models.Envelope{Type: models.TypeRetract, Retract: retract}); err != nil {
+				l.Logger.Println("Error deleting message: ", err)
+			}
+		})
+	Pages.AddPage("modal", modal, true, true)
+}
+
+// chooseReaction offers a few emoji to react to the message with, the one already chosen is removed
+func (l *Loro) chooseReaction(msg *models.Message) {
+	if msg == nil || msg.ID == nil || msg.Notice || msg.DeletedAt != nil || l.selectedChat == nil {
+		return
+	}
+	chatID := *l.selectedChat.ChatID
+
+	modal := tview.NewModal().
+		SetText("React with").
+		AddButtons([]string{"👍", "❤️", "😂", "😮", "😢", "🙏", "Cancel"}).
+		SetDoneFunc(func(_ int, label string) {
+			Pages.RemovePage("modal")
+			l.Application.SetFocus(chatMesssages)
+			if label == "" || label == "Cancel" {
+				return
+			}
+			reaction := &models.Reaction{ChatID: chatID, MessageID: *msg.ID, Emoji: label}
+			if err := l.NetworkClient.Send(&models.Envelope{Type: models.TypeReaction, Reaction: reaction}); err != nil {
+				l.Logger.Println("Error sending reaction: ", err)
+			}
+		})
+	Pages.AddPage("modal", modal, true, true)
+}
+
+/*
+showThread lists every reply to the message, oldest first. Down on the last
+reply loads more, Escape goes back to the chat.
+*/
+func (l *Loro) showThread(msg *models.Message) {
+	if msg == nil || msg.ID == nil || msg.Sender == nil || msg.Notice || l.selectedChat == nil {
+		return
+	}
+	chatID, rootID := *l.selectedChat.ChatID, *msg.ID
+
+	thread := tview.NewTable().SetSelectable(true, false)
+	thread.SetSelectedStyle(style.CellSelectedtyle)
+	thread.SetBorder(true).SetTitle(" thread ")
+	root := "message deleted"
+	if msg.Body != nil && msg.DeletedAt == nil {
+		root = *msg.Body
+	}
+	thread.SetCell(0, 0, tview.NewTableCell(l.displayName(*msg.Sender)+": "+root).SetExpansion(1).SetSelectable(false))
+
+	loaded, done := 0, false
+	loadMore := func() {
+		if done {
+			return
+		}
+		replies, err := l.GetThread(chatID, rootID, l.limit, loaded)
+		if err != nil {
+			l.Logger.Println("Error fetching thread: ", err)
+			return
+		}
+		done = len(replies) < l.limit
+		for _, reply := range replies {
+			loaded++
+			text := "message deleted"
+			if reply.Body != nil && reply.DeletedAt == nil {
+				text = *reply.Body
+			}
+			if reply.Sender != nil {
+				text = l.displayName(*reply.Sender) + ": " + text
+			}
+			cell := tview.NewTableCell("  " + text).SetExpansion(1)
+			cell.SetTextColor(style.LoroTheme.SecondaryTextColor)
+			thread.SetCell(loaded, 0, cell)
+		}
+	}
+	loadMore()
+
+	thread.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEscape:
+			Pages.RemovePage("modal")
+			l.Application.SetFocus(chatMesssages)
+			return nil
+		case tcell.KeyDown:
+			if row, _ := thread.GetSelection(); row == loaded {
+				loadMore()
+			}
+		}
+		return event
+	})
+
+	Pages.AddPage("modal", thread, true, true)
+	l.Application.SetFocus(thread)
+}
+
+// saveAttachment downloads the file of the message into the downloads folder of the user
+func (l *Loro) saveAttachment(msg *models.Message) {
+	if msg == nil || msg.Attachment == nil || msg.DeletedAt != nil {
+		return
+	}
+
+	dir, err := os.UserHomeDir()
+	if err == nil {
+		dir = filepath.Join(dir, "Downloads")
+	}
+	if err != nil || os.MkdirAll(dir, 0o755) != nil {
+		dir = "."
+	}
+
+	path, err := l.Download(msg.Attachment, dir)
+	if err != nil {
+		l.Logger.Println("Error saving file: ", err)
+		l.notify("Could not save the file: " + err.Error())
+		return
+	}
+	l.notify("Saved to " + path)
+}
+
+// setChatState stores how the user files the chat and draws the list again
+func (l *Loro) setChatState(chat *models.Chat, update models.ChatStateUpdate) {
+	updated, err := l.SetChatState(*chat.ChatID, update)
+	if err != nil {
+		l.Logger.Println("Error changing the chat: ", err)
+		l.notify("Could not change the chat: " + err.Error())
+		return
+	}
+	chat.SetState(updated)
+	l.renderChatList()
+	l.Application.SetFocus(chatList)
+}
+
+func (l *Loro) toggleArchive(chat *models.Chat) {
+	if chat == nil {
+		return
+	}
+	archived := !chat.Archived
+	l.setChatState(chat, models.ChatStateUpdate{Archived: &archived})
+}
+
+// confirmHide hides the chat from both lists until its next message
+func (l *Loro) confirmHide(chat *models.Chat) {
+	if chat == nil {
+		return
+	}
+	modal := tview.NewModal().
+		SetText("Hide " + l.chatTitle(chat) + " until a new message arrives?").
+		AddButtons([]string{"Hide", "Cancel"}).
+		SetDoneFunc(func(_ int, label string) {
+			Pages.RemovePage("modal")
+			l.Application.SetFocus(chatList)
+			if label != "Hide" {
+				return
+			}
+			hidden := true
+			l.setChatState(chat, models.ChatStateUpdate{Hidden: &hidden})
+		})
+	Pages.AddPage("modal", modal, true, true)
+}
+
+// chooseMute mutes the chat for a while, or unmutes it
+func (l *Loro) chooseMute(chat *models.Chat) {
+	if chat == nil {
+		return
+	}
+	durations := map[string]time.Duration{
+		"1 hour":  time.Hour,
+		"8 hours": 8 * time.Hour,
+		"1 week":  7 * 24 * time.Hour,
+		"1 year":  365 * 24 * time.Hour,
+	}
+	buttons := []string{"1 hour", "8 hours", "1 week", "1 year"}
+	if chat.Muted() {
+		buttons = append(buttons, "Unmute")
+	}
+	buttons = append(buttons, "Cancel")
+
+	modal := tview.NewModal().
+		SetText("Mute " + l.chatTitle(chat) + " for").
+		AddButtons(buttons).
+		SetDoneFunc(func(_ int, label string) {
+			Pages.RemovePage("modal")
+			l.Application.SetFocus(chatList)
+			var until time.Time
+			if label == "Unmute" {
+				// a time already past unmutes
+				until = time.Now()
+			} else if duration, ok := durations[label]; ok {
+				until = time.Now().Add(duration)
+			} else {
+				return
+			}
+			l.setChatState(chat, models.ChatStateUpdate{MutedUntil: &until})
+		})
+	Pages.AddPage("modal", modal, true, true)
+}
+
+// loadBlocks fetches who the user blocked
+func (l *Loro) loadBlocks() {
+	blocks, err := l.GetBlocks()
+	if err != nil {
+		l.Logger.Println("Error fetching blocked users: ", err)
+		return
+	}
+	l.blocked = make(map[string]bool, len(blocks))
+	for _, block := range blocks {
+		l.blocked[block.Username] = true
+	}
+}
+
+// receiveBlock follows blocks made from any session of the user
+func (l *Loro) receiveBlock(block *models.Block) {
+	if block.Blocked {
+		l.blocked[block.Username] = true
+	} else {
+		delete(l.blocked, block.Username)
+	}
+	l.renderChatList()
+}
+
+// confirmBlock blocks the user, or unblocks them when they are blocked already, then focuses back
+func (l *Loro) confirmBlock(username string, back tview.Primitive) {
+	blocked := !l.blocked[username]
+	text, button := "Block "+username+"? They will not be able to write to you.", "Block"
+	if !blocked {
+		text, button = "Unblock "+username+"?", "Unblock"
+	}
+
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons([]string{button, "Cancel"}).
+		SetDoneFunc(func(_ int, label string) {
+			Pages.RemovePage("modal")
+			l.Application.SetFocus(back)
+			if label != button {
+				return
+			}
+			if err := l.Block(username, blocked); err != nil {
+				l.Logger.Println("Error blocking user: ", err)
+				l.notify("Could not change the block: " + err.Error())
+			}
+			// the block frame updates the list
+		})
+	Pages.AddPage("modal", modal, true, true)
+}
+
+// showBlocked lists the blocked users, choosing one offers to unblock them
+func (l *Loro) showBlocked() {
+	blocks, err := l.GetBlocks()
+	if err != nil {
+		l.Logger.Println("Error fetching blocked users: ", err)
+		l.notify("Could not load the blocked users: " + err.Error())
+		return
+	}
+
+	list := tview.NewList().ShowSecondaryText(true)
+	list.SetBorder(true).SetTitle(" blocked ")
+	for _, block := range blocks {
+		username := block.Username
+		list.AddItem(username, "since "+block.BlockedAt.Local().Format("Jan 2 2006"), 0, func() {
+			Pages.RemovePage("modal")
+			l.confirmBlock(username, buttonBlocked)
+		})
+	}
+	list.SetDoneFunc(func() {
+		Pages.RemovePage("modal")
+		l.Application.SetFocus(buttonBlocked)
+	})
+	if len(blocks) == 0 {
+		list.AddItem("No blocked users", "", 0, nil)
+	}
+
+	Pages.AddPage("modal", list, true, true)
+	l.Application.SetFocus(list)
+}
+
+// loadContacts fetches the contacts with their nicknames and the contact requests
+func (l *Loro) loadContacts() {
+	contacts, err := l.GetContacts()
+	if err != nil {
+		l.Logger.Println("Error fetching contacts: ", err)
+		return
+	}
+	l.contacts = contacts
+	l.nicknames = make(map[string]string, len(contacts))
+	for _, contact := range contacts {
+		if contact.Nickname != nil {
+			l.nicknames[contact.Username] = *contact.Nickname
+		}
+	}
+
+	requests, err := l.GetContactRequests()
+	if err != nil {
+		l.Logger.Println("Error fetching contact requests: ", err)
+		return
+	}
+	l.contactRequests = requests
+	l.renderContacts()
+}
+
+// renderContacts shows how many contact requests wait for an answer on their button
+func (l *Loro) renderContacts() {
+	if l.contactRequests == nil || len(l.contactRequests.Incoming) == 0 {
+		buttonContacts.SetLabel("Contacts")
+		return
+	}
+	buttonContacts.SetLabel(fmt.Sprintf("Contacts (%d)", len(l.contactRequests.Incoming)))
+}
+
+/*
+showContacts lists the contact requests waiting for the user, the ones they
+sent and their contacts. Choosing any of them offers what can be done with it.
+*/
+func (l *Loro) showContacts() {
+	list := tview.NewList().ShowSecondaryText(true)
+	list.SetBorder(true).SetTitle(" contacts ")
+	closeContacts := func() {
+		Pages.RemovePage("modal")
+		l.Application.SetFocus(buttonContacts)
+	}
+
+	list.AddItem("Add contact", "send a contact request", 0, func() {
+		l.askText("Username", "", func(username string) {
+			if err := l.RequestContact(username); err != nil {
+				l.Logger.Println("Error requesting contact: ", err)
+				l.notify("Could not send the request: " + err.Error())
+			}
+		})
+	})
+	if requests := l.contactRequests; requests != nil {
+		for _, request := range requests.Incoming {
+			username := request.Username
+			list.AddItem(username, "wants to be your contact", 0, func() {
+				l.choose(username+" wants to be your contact", []string{"Accept", "Decline"}, func(label string) {
+					if err := l.AnswerContact(username, label == "Accept"); err != nil {
+						l.Logger.Println("Error answering contact request: ", err)
+						l.notify("Could not answer the request: " + err.Error())
+					}
+				})
+			})
+		}
+		for _, request := range requests.Outgoing {
+			username := request.Username
+			list.AddItem(username, "request sent", 0, func() {
+				l.choose("Withdraw the request to "+username+"?", []string{"Withdraw"}, func(string) {
+					if err := l.AnswerContact(username, false); err != nil {
+						l.Logger.Println("Error withdrawing contact request: ", err)
+						l.notify("Could not withdraw the request: " + err.Error())
+					}
+				})
+			})
+		}
+	}
+	for _, contact := range l.contacts {
+		username := contact.Username
+		title := l.displayName(username)
+		if title != username {
+			title += " (" + username + ")"
+		}
+		seen := ""
+		if contact.Online {
+			seen = "online"
+		} else if contact.LastSeenAt != nil {
+			seen = lastSeen(*contact.LastSeenAt)
+		}
+		list.AddItem(title, seen, 0, func() {
+			l.choose(title, []string{"Message", "Nickname", "Remove"}, func(label string) {
+				switch label {
+				case "Message":
+					l.writeTo(username)
+				case "Nickname":
+					l.askText("Nickname", l.nicknames[username], func(nickname string) {
+						if err := l.SetNickname(username, nickname); err != nil {
+							l.Logger.Println("Error naming contact: ", err)
+							l.notify("Could not change the nickname: " + err.Error())
+							return
+						}
+						l.loadContacts()
+						l.renderChatList()
+					})
+				case "Remove":
+					if err := l.RemoveContact(username); err != nil {
+						l.Logger.Println("Error removing contact: ", err)
+						l.notify("Could not remove the contact: " + err.Error())
+					}
+				}
+			})
+		})
+	}
+	list.SetDoneFunc(closeContacts)
+
+	Pages.AddPage("modal", list, true, true)
+	l.Application.SetFocus(list)
+}
+
+// choose asks which of the actions to take, Cancel takes none
+func (l *Loro) choose(text string, actions []string, done func(label string)) {
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons(append(actions, "Cancel")).
+		SetDoneFunc(func(_ int, label string) {
+			Pages.RemovePage("modal")
+			l.Application.SetFocus(buttonContacts)
+			if label != "" && label != "Cancel" {
+				done(label)
+			}
+		})
+	Pages.AddPage("modal", modal, true, true)
+}
+
+// askText asks for a line of text, Enter hands it to done and Escape gives up
+func (l *Loro) askText(label, text string, done func(text string)) {
+	field := tview.NewInputField().SetLabel(label + ": ").SetText(text)
+	field.SetBorder(true)
+	field.SetLabelColor(style.LoroTheme.SecondaryTextColor)
+	field.SetFieldBackgroundColor(style.LoroTheme.MoreContrastBackgroundColor)
+	field.SetDoneFunc(func(key tcell.Key) {
+		Pages.RemovePage("modal")
+		l.Application.SetFocus(buttonContacts)
+		if key == tcell.KeyEnter {
+			done(strings.TrimSpace(field.GetText()))
+		}
+	})
+	Pages.AddPage("modal", centered(field, 50, 3), true, true)
+	l.Application.SetFocus(field)
+}
+
+// writeTo opens the direct chat with the user, or asks for a first message when there is none
+func (l *Loro) writeTo(username string) {
+	for _, chatID := range l.chatList {
+		if chat := l.chatsMap[chatID]; !chat.IsGroup() && chat.Username == username {
+			chat.Archived, chat.Hidden = false, false
+			if !l.listed(chat) {
+				// archived chats are only in the other list
+				l.archivedView = false
+				buttonArchived.SetLabel("Archived")
+			}
+			l.renderChatList()
+			l.openChat(chatID, 0)
+			return
+		}
+	}
+	l.askText("Message to "+l.displayName(username), "", func(body string) {
+		if body == "" {
+			return
+		}
+		message := &models.Message{MessagePayload: models.MessagePayload{
+			Receiver: &username,
+			Sender:   &l.username,
+			Body:     &body,
+		}}
+		l.MessageEvents <- &models.MessageEvent{Type: models.Forward, Message: message}
+	})
+}
+
+// notify shows a short text until the user closes it
+func (l *Loro) notify(text string) {
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons([]string{"OK"}).
+		SetDoneFunc(func(_ int, _ string) {
+			Pages.RemovePage("modal")
+			l.Application.SetFocus(chatMesssages)
+		})
+	Pages.AddPage("modal", modal, true, true)
+}
+
+/*
+searchQuery turns what the user typed into the query of a search. Besides
+words it takes from:username, after:2006-01-02, before:2006-01-02 and in:here
+for the open chat only.
+*/
+func (l *Loro) searchQuery(text string) url.Values {
+	query := url.Values{}
+	words := make([]string, 0)
+	for _, word := range strings.Fields(text) {
+		switch {
+		case strings.HasPrefix(word, "from:"):
+			query.Set("sender", strings.TrimPrefix(word, "from:"))
+		case strings.HasPrefix(word, "after:"):
+			query.Set("from", strings.TrimPrefix(word, "after:"))
+		case strings.HasPrefix(word, "before:"):
+			query.Set("to", strings.TrimPrefix(word, "before:"))
+		case word == "in:here" && l.selectedChat != nil:
+			query.Set("chat", strconv.Itoa(*l.selectedChat.ChatID))
+		default:
+			words = append(words, word)
+		}
+	}
+	query.Set("q", strings.Join(words, " "))
+	return query
+}
+
+// showSearch looks for messages in every chat, choosing a hit opens its chat at the message
+func (l *Loro) showSearch() {
+	input := tview.NewInputField().SetLabel("Search: ")
+	input.SetFieldBackgroundColor(style.LoroTheme.MoreContrastBackgroundColor)
+	input.SetFieldTextColor(style.LoroTheme.PrimitiveBackgroundColor)
+	results := tview.NewList().ShowSecondaryText(true)
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(input, 1, 1, true).
+		AddItem(results, 0, 1, false)
+	layout.SetBorder(true).SetTitle(" search: from:user after:date before:date in:here ")
+
+	closeSearch := func() {
+		Pages.RemovePage("modal")
+		l.Application.SetFocus(buttonSearch)
+	}
+
+	var search func(query url.Values, more bool)
+	search = func(query url.Values, more bool) {
+		page, err := l.Search(query)
+		if err != nil {
+			l.Logger.Println("Error searching: ", err)
+			return
+		}
+		if more {
+			// the last item asked for this page
+			results.RemoveItem(results.GetItemCount() - 1)
+		} else {
+			results.Clear()
+		}
+		for _, hit := range page.Hits {
+			chatID, messageID := hit.ChatID, hit.MessageID
+			title := fmt.Sprintf("chat %d", chatID)
+			if chat := l.chatsMap[chatID]; chat != nil {
+				title = l.chatTitle(chat)
+			}
+			if hit.Sender != nil {
+				title += " · " + l.displayName(*hit.Sender)
+			}
+			title += " · " + hit.CreatedAt.Local().Format(time.DateTime)
+			snippet := strings.NewReplacer("<mark>", "[::u]", "</mark>", "[::-]").Replace(tview.Escape(hit.Snippet))
+			results.AddItem(title, snippet, 0, func() {
+				closeSearch()
+				l.openChat(chatID, messageID)
+			})
+		}
+		if page.NextCursor != nil {
+			next := url.Values{}
+			for key, values := range query {
+				next[key] = values
+			}
+			next.Set("cursor", *page.NextCursor)
+			results.AddItem("More results", "", 0, func() { search(next, true) })
+		}
+		if results.GetItemCount() == 0 {
+			results.AddItem("Nothing found", "", 0, nil)
+		}
+	}
+
+	input.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEnter:
+			if strings.TrimSpace(input.GetText()) == "" {
+				return
+			}
+			search(l.searchQuery(input.GetText()), false)
+			l.Application.SetFocus(results)
+		case tcell.KeyEscape:
+			closeSearch()
+		}
+	})
+	results.SetDoneFunc(closeSearch)
+	results.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyTab {
+			l.Application.SetFocus(input)
+			return nil
+		}
+		return event
+	})
+
+	Pages.AddPage("modal", layout, true, true)
+	l.Application.SetFocus(input)
+}
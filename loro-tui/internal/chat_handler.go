@@ -1,9 +1,25 @@
 package internal
 
-import "loro-tui/internal/models"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"loro-tui/internal/models"
+	"sort"
+	"time"
+)
+
+const (
+	// how often a typing start is repeated while the user keeps typing
+	typingRefresh = 3 * time.Second
+	// a typing indicator without news disappears after this, in case its stop was lost
+	typingTTL = 2 * typingRefresh
+)
 
 type ChatMessages struct {
-	offset   int
+	// whether the newest page was fetched, live messages may come before it
+	fetched bool
+	// where the page of older messages starts, nil once there are none
+	older    *string
 	messages []*models.Message
 }
 
@@ -13,6 +29,37 @@ type ChatHandler struct {
 	messagesMap  map[int]*ChatMessages
 	chatsMap     map[int]*models.Chat
 	chatList     []int
+	// chats drawn in the list, two rows each
+	shown []int
+	// whether the list shows the archived chats instead of the others
+	archivedView bool
+	// messages sent by this client waiting for an ack, oldest first
+	pending []*models.Message
+	// last message read by each member, by chat and username
+	readCursors map[int]map[string]int
+	// last message this client reported as read, by chat
+	lastRead map[int]int
+	// when the typing indicator of each member expires, by chat and username
+	typing map[int]map[string]time.Time
+	// whether the peers of direct chats are online, by username
+	presence map[string]*models.Presence
+	// users the user blocked
+	blocked map[string]bool
+	// names the user gave their contacts, by username
+	nicknames map[string]string
+	contacts  []*models.ContactEntry
+	// contact requests sent to the user and the ones they sent
+	contactRequests *models.ContactRequests
+	// last message sequence this client has of each chat, sent when resuming
+	seqs map[int]int64
+	// message drawn in each row of the open chat, a reaction row belongs to the message above
+	rows []*models.Message
+	// mentions of the user not read yet, newest first
+	mentions []*models.Mention
+	// pinned messages by chat, the latest pinned first
+	pins map[int][]*models.Pin
+	// which pin of the open chat the pinned bar shows
+	pinShown int
 }
 
 func NewChatHandler(limit int) *ChatHandler {
@@ -22,16 +69,274 @@ func NewChatHandler(limit int) *ChatHandler {
 		chatsMap:     make(map[int]*models.Chat),
 		chatList:     make([]int, 0),
 		selectedChat: nil,
+		pending:      make([]*models.Message, 0),
+		readCursors:  make(map[int]map[string]int),
+		lastRead:     make(map[int]int),
+		typing:       make(map[int]map[string]time.Time),
+		presence:     make(map[string]*models.Presence),
+		blocked:      make(map[string]bool),
+		nicknames:    make(map[string]string),
+		seqs:         make(map[int]int64),
+		pins:         make(map[int][]*models.Pin),
+	}
+}
+
+// newClientID identifies a message so the server stores it once however many times it is sent
+func newClientID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+/*
+pushMessage adds the message at the top of the chat history. A message the
+server echoes back replaces the local copy written by this client, and a
+message replayed after reconnecting replaces the copy already shown.
+*/
+func (c *ChatHandler) pushMessage(chatID int, msg *models.Message) *ChatMessages {
+	cmsgs, ok := c.messagesMap[chatID]
+	if !ok {
+		cmsgs = &ChatMessages{messages: make([]*models.Message, 0)}
+		c.messagesMap[chatID] = cmsgs
+	}
+	c.noteSeq(chatID, msg.Seq)
+
+	for i, m := range cmsgs.messages {
+		if sameMessage(m, msg) {
+			cmsgs.messages[i] = msg
+			return cmsgs
+		}
+	}
+
+	cmsgs.messages = append([]*models.Message{msg}, cmsgs.messages...)
+	return cmsgs
+}
+
+func sameMessage(a, b *models.Message) bool {
+	if a.ClientID != nil && b.ClientID != nil {
+		return *a.ClientID == *b.ClientID
+	}
+	return a.ID != nil && b.ID != nil && *a.ID == *b.ID
+}
+
+// noteSeq remembers the newest sequence this client has of the chat
+func (c *ChatHandler) noteSeq(chatID int, seq *int64) {
+	if seq != nil && *seq > c.seqs[chatID] {
+		c.seqs[chatID] = *seq
+	}
+}
+
+// seqCursors lists the last sequence of every known chat
+func (c *ChatHandler) seqCursors() []models.SeqCursor {
+	cursors := make([]models.SeqCursor, 0, len(c.chatsMap))
+	for chatID := range c.chatsMap {
+		cursors = append(cursors, models.SeqCursor{ChatID: chatID, Seq: c.seqs[chatID]})
+	}
+	return cursors
+}
+
+// lastSentBy returns the newest stored message of the user in the chat
+func (c *ChatHandler) lastSentBy(chatID int, username string) *models.Message {
+	cmsgs, ok := c.messagesMap[chatID]
+	if !ok {
+		return nil
+	}
+	for _, msg := range cmsgs.messages {
+		if msg.ID != nil && !msg.Notice && msg.Sender != nil && *msg.Sender == username {
+			return msg
+		}
+	}
+	return nil
+}
+
+// applyEdit changes the body of a message already loaded and reports whether it was
+func (c *ChatHandler) applyEdit(edit *models.Edit) bool {
+	cmsgs, ok := c.messagesMap[edit.ChatID]
+	if !ok {
+		return false
+	}
+	for _, msg := range cmsgs.messages {
+		if msg.ID != nil && *msg.ID == edit.MessageID {
+			body := edit.Body
+			msg.Body = &body
+			msg.EditedAt = edit.EditedAt
+			return true
+		}
+	}
+	return false
+}
+
+// applyRetract empties a message already loaded and reports whether it was
+func (c *ChatHandler) applyRetract(retract *models.Retract) bool {
+	cmsgs, ok := c.messagesMap[retract.ChatID]
+	if !ok {
+		return false
+	}
+	for _, msg := range cmsgs.messages {
+		if msg.ID != nil && *msg.ID == retract.MessageID {
+			msg.Body = nil
+			msg.DeletedAt = retract.DeletedAt
+			if msg.DeletedAt == nil {
+				now := time.Now()
+				msg.DeletedAt = &now
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// applyReaction takes the new reaction counts of a message already loaded and reports whether it was
+func (c *ChatHandler) applyReaction(reaction *models.Reaction) bool {
+	cmsgs, ok := c.messagesMap[reaction.ChatID]
+	if !ok {
+		return false
+	}
+	for _, msg := range cmsgs.messages {
+		if msg.ID != nil && *msg.ID == reaction.MessageID {
+			msg.Reactions = reaction.Reactions
+			return true
+		}
+	}
+	return false
+}
+
+// addMention keeps a mention not known yet and reports whether it was new
+func (c *ChatHandler) addMention(mention *models.Mention) bool {
+	for _, known := range c.mentions {
+		if known.MessageID == mention.MessageID {
+			return false
+		}
+	}
+	c.mentions = append([]*models.Mention{mention}, c.mentions...)
+	return true
+}
+
+// dropMentions forgets the mentions of the chat up to the message, they were read
+func (c *ChatHandler) dropMentions(chatID, messageID int) {
+	kept := c.mentions[:0]
+	for _, mention := range c.mentions {
+		if mention.ChatID != chatID || mention.MessageID > messageID {
+			kept = append(kept, mention)
+		}
+	}
+	c.mentions = kept
+}
+
+// applyPin adds or removes a pin of a chat
+func (c *ChatHandler) applyPin(pin *models.Pin) {
+	c.dropPin(pin.ChatID, pin.MessageID)
+	if pin.Pinned {
+		c.pins[pin.ChatID] = append([]*models.Pin{pin}, c.pins[pin.ChatID]...)
+	}
+}
+
+// dropPin removes the pin of a message, for example because it was deleted
+func (c *ChatHandler) dropPin(chatID, messageID int) {
+	pins := c.pins[chatID]
+	for i, pin := range pins {
+		if pin.MessageID == messageID {
+			c.pins[chatID] = append(pins[:i:i], pins[i+1:]...)
+			return
+		}
+	}
+}
+
+// editPin keeps the body of a pinned message up to date
+func (c *ChatHandler) editPin(edit *models.Edit) {
+	for _, pin := range c.pins[edit.ChatID] {
+		if pin.MessageID == edit.MessageID {
+			body := edit.Body
+			pin.Body = &body
+		}
+	}
+}
+
+func (c *ChatHandler) isPinned(chatID, messageID int) bool {
+	for _, pin := range c.pins[chatID] {
+		if pin.MessageID == messageID {
+			return true
+		}
+	}
+	return false
+}
+
+// rowOf returns the first row the message is drawn in, -1 when it is not
+func (c *ChatHandler) rowOf(messageID int) int {
+	for row, msg := range c.rows {
+		if msg.ID != nil && *msg.ID == messageID {
+			return row
+		}
+	}
+	return -1
+}
+
+// messageAt returns the message shown in a row of the selected chat
+func (c *ChatHandler) messageAt(row int) *models.Message {
+	if row < 0 || row >= len(c.rows) {
+		return nil
+	}
+	return c.rows[row]
+}
+
+// takePending removes and returns the pending message with the client id
+func (c *ChatHandler) takePending(clientID *string) *models.Message {
+	if clientID == nil {
+		return nil
 	}
+	for i, msg := range c.pending {
+		if *msg.ClientID == *clientID {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return msg
+		}
+	}
+	return nil
 }
 
 func (c *ChatHandler) saveChats(chats []*models.Chat) {
+	c.chatList = make([]int, 0, len(chats))
 	for _, chat := range chats {
 		c.chatsMap[*chat.ChatID] = chat
 		c.chatList = append(c.chatList, *chat.ChatID)
+		c.noteSeq(*chat.ChatID, chat.LastSeq)
 	}
 }
 
+// displayName is the nickname the user gave the username, or the username
+func (c *ChatHandler) displayName(username string) string {
+	if nickname, ok := c.nicknames[username]; ok {
+		return nickname
+	}
+	return username
+}
+
+// chatTitle names direct chats after the nickname of the other user when they have one
+func (c *ChatHandler) chatTitle(chat *models.Chat) string {
+	if !chat.IsGroup() {
+		return c.displayName(chat.Username)
+	}
+	return chat.Title()
+}
+
+// listed tells whether the chat belongs in the list being shown
+func (c *ChatHandler) listed(chat *models.Chat) bool {
+	if chat.Hidden {
+		return false
+	}
+	return chat.Archived == c.archivedView
+}
+
+// peers lists the other user of every direct chat
+func (c *ChatHandler) peers() []string {
+	usernames := make([]string, 0, len(c.chatList))
+	for _, chatID := range c.chatList {
+		if chat := c.chatsMap[chatID]; !chat.IsGroup() && chat.Username != "" {
+			usernames = append(usernames, chat.Username)
+		}
+	}
+	return usernames
+}
+
 // TODO refactor this logic
 func (c *ChatHandler) setChatFirst(chatID int) {
 	newList := make([]int, 0)
@@ -50,18 +355,110 @@ func (c *ChatHandler) setChatFirst(chatID int) {
 	c.chatList = newList
 }
 
-func (c *ChatHandler) saveMessages(chatID int, messages []*models.Message) *ChatMessages {
-	if cmsgs, ok := c.messagesMap[chatID]; ok {
-		cmsgs.offset += c.limit
-		cmsgs.messages = append(cmsgs.messages, messages...)
-	} else {
-		cmsgs := &ChatMessages{
-			offset:   c.limit,
-			messages: make([]*models.Message, 0),
-		}
-		cmsgs.messages = append(cmsgs.messages, messages...)
+/*
+saveMessages appends a page of older messages, newest first. Messages that
+arrived live before the first page was fetched are in it too and kept once.
+*/
+func (c *ChatHandler) saveMessages(chatID int, page *models.MessagePage) *ChatMessages {
+	cmsgs, ok := c.messagesMap[chatID]
+	if !ok {
+		cmsgs = &ChatMessages{messages: make([]*models.Message, 0)}
 		c.messagesMap[chatID] = cmsgs
 	}
+	cmsgs.fetched = true
+	cmsgs.older = page.NextCursor
 
-	return c.messagesMap[chatID]
+	for _, msg := range page.Messages {
+		c.noteSeq(chatID, msg.Seq)
+		if !containsMessage(cmsgs.messages, msg) {
+			cmsgs.messages = append(cmsgs.messages, msg)
+		}
+	}
+	return cmsgs
+}
+
+func containsMessage(messages []*models.Message, msg *models.Message) bool {
+	for _, m := range messages {
+		if sameMessage(m, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ChatHandler) setReadCursor(chatID int, username string, messageID int) {
+	cursors, ok := c.readCursors[chatID]
+	if !ok {
+		cursors = make(map[string]int)
+		c.readCursors[chatID] = cursors
+	}
+	if messageID > cursors[username] {
+		cursors[username] = messageID
+	}
+}
+
+// seenBy counts the members other than the sender who read the message
+func (c *ChatHandler) seenBy(chatID int, msg *models.Message) int {
+	if msg.ID == nil || msg.Sender == nil {
+		return 0
+	}
+	seen := 0
+	for username, messageID := range c.readCursors[chatID] {
+		if username != *msg.Sender && messageID >= *msg.ID {
+			seen++
+		}
+	}
+	return seen
+}
+
+// newestFromOthers returns the id of the latest stored message not written by username
+func (c *ChatHandler) newestFromOthers(chatID int, username string) int {
+	cmsgs, ok := c.messagesMap[chatID]
+	if !ok {
+		return 0
+	}
+	for _, msg := range cmsgs.messages {
+		if msg.ID != nil && msg.Sender != nil && *msg.Sender != username {
+			return *msg.ID
+		}
+	}
+	return 0
+}
+
+func (c *ChatHandler) setTyping(chatID int, username string, typing bool) {
+	members, ok := c.typing[chatID]
+	if !ok {
+		members = make(map[string]time.Time)
+		c.typing[chatID] = members
+	}
+	if typing {
+		members[username] = time.Now().Add(typingTTL)
+	} else {
+		delete(members, username)
+	}
+}
+
+// typingIn lists who is typing in the chat, sorted by name
+func (c *ChatHandler) typingIn(chatID int) []string {
+	usernames := make([]string, 0, len(c.typing[chatID]))
+	for username := range c.typing[chatID] {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// expireTyping drops indicators whose stop never arrived and returns the chats that changed
+func (c *ChatHandler) expireTyping() []int {
+	changed := make([]int, 0)
+	now := time.Now()
+	for chatID, members := range c.typing {
+		for username, expires := range members {
+			if now.After(expires) {
+				delete(members, username)
+				changed = append(changed, chatID)
+			}
+		}
+	}
+	return changed
 }
@@ -1,19 +1,82 @@
 package models
 
+import "time"
+
 const (
 	SortChat    = 0
 	NewChat     = 1
 	FetchChats  = 2
 	GetMessages = 3
 	LoadChat    = 4
+	// selects a message of the open chat, loading older pages until it shows up
+	JumpToMessage = 5
+)
+
+const (
+	DirectChat = "direct"
+	GroupChat  = "group"
 )
 
 type ChatEvent struct {
 	Type   int
 	ChatID int
+	// the message to select once the chat is loaded, 0 for none
+	MessageID int
 }
 
 type Chat struct {
-	ChatID   *int   `json:"id"`
-	Username string `json:"username"`
+	ChatID   *int    `json:"id"`
+	Username string  `json:"username"`
+	Type     string  `json:"type"`
+	Name     *string `json:"name,omitempty"`
+	LastSeq  *int64  `json:"last_seq"`
+	// body and time of the newest message, shown under the title
+	LastMessage     *string    `json:"last_message"`
+	LastMessageTime *time.Time `json:"last_message_time"`
+	// messages of others the user has not read yet
+	Unread int `json:"unread"`
+	// archived chats have their own list, hidden ones show up again with the next message
+	Archived   bool       `json:"archived"`
+	Hidden     bool       `json:"hidden"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+}
+
+// Title is the name shown in the chat list
+func (c *Chat) Title() string {
+	if c.Type == GroupChat && c.Name != nil {
+		return "# " + *c.Name
+	}
+	return c.Username
+}
+
+// SetLastMessage makes the message the preview of the chat
+func (c *Chat) SetLastMessage(msg *Message) {
+	body := ""
+	if msg.Body != nil {
+		body = *msg.Body
+	}
+	if body == "" && msg.Attachment != nil {
+		body = "📎 " + msg.Attachment.Filename
+	}
+	c.LastMessage = &body
+	if msg.CreatedAt != nil {
+		c.LastMessageTime = msg.CreatedAt
+	} else {
+		now := time.Now()
+		c.LastMessageTime = &now
+	}
+}
+
+func (c *Chat) Muted() bool {
+	return c.MutedUntil != nil && c.MutedUntil.After(time.Now())
+}
+
+func (c *Chat) SetState(state *ChatState) {
+	c.Archived = state.Archived
+	c.Hidden = state.Hidden
+	c.MutedUntil = state.MutedUntil
+}
+
+func (c *Chat) IsGroup() bool {
+	return c.Type == GroupChat
 }
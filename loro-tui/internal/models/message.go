@@ -1,22 +1,101 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	protocol "loro-protocol"
+)
+
+const (
+	Forward      = 0
+	Incoming     = 1
+	Disconnected = 2
+	Reconnected  = 3
+)
+
+// Frames are defined in the protocol module shared with the server
+type (
+	Envelope       = protocol.Envelope
+	MessagePayload = protocol.Message
+	Ack            = protocol.Ack
+	FrameError     = protocol.Error
+	Read           = protocol.Read
+	Receipt        = protocol.Receipt
+	Typing         = protocol.Typing
+	Presence       = protocol.Presence
+	Resume         = protocol.Resume
+	SeqCursor      = protocol.SeqCursor
+	Resumed        = protocol.Resumed
+	System         = protocol.System
+	Edit           = protocol.Edit
+	Retract        = protocol.Retract
+	Reaction       = protocol.Reaction
+	ReactionCount  = protocol.ReactionCount
+	Quote          = protocol.Quote
+	Mention        = protocol.Mention
+	Pin            = protocol.Pin
+	Unread         = protocol.Unread
+	Block          = protocol.Block
+	Contact        = protocol.Contact
+	Attachment     = protocol.Attachment
+)
 
 const (
-	Forward  = 0
-	Incoming = 1
+	TypeMessage  = protocol.TypeMessage
+	TypeAck      = protocol.TypeAck
+	TypeError    = protocol.TypeError
+	TypeRead     = protocol.TypeRead
+	TypeReceipt  = protocol.TypeReceipt
+	TypeTyping   = protocol.TypeTyping
+	TypePresence = protocol.TypePresence
+	TypeResume   = protocol.TypeResume
+	TypeResumed  = protocol.TypeResumed
+	TypeSystem   = protocol.TypeSystem
+	TypeEdit     = protocol.TypeEdit
+	TypeRetract  = protocol.TypeRetract
+	TypeReaction = protocol.TypeReaction
+	TypeMention  = protocol.TypeMention
+	TypePin      = protocol.TypePin
+	TypeUnread   = protocol.TypeUnread
+	TypeBlock    = protocol.TypeBlock
+	TypeContact  = protocol.TypeContact
+
+	TypingStart = protocol.TypingStart
+	TypingStop  = protocol.TypingStop
+
+	ContactRequested = protocol.ContactRequested
+	ContactAccepted  = protocol.ContactAccepted
+	ContactDeclined  = protocol.ContactDeclined
+	ContactRemoved   = protocol.ContactRemoved
 )
 
+// Delivery status of a message written in this client
+const (
+	StatusSent    = 0
+	StatusPending = 1
+	StatusFailed  = 2
+)
+
+// Message is a chat message as this client shows it
 type Message struct {
-	ID        *int       `json:"id,omitempty"`
-	Body      *string    `json:"body,omitempty"`
-	Sender    *string    `json:"sender,omitempty"`
-	Receiver  *string    `json:"receiver,omitempty"`
-	ChatID    *int       `json:"chatId,omitempty"`
-	CreatedAt *time.Time `json:"created_at,omitempty"`
+	MessagePayload
+	Status int `json:"-"`
+	// why the server refused the message
+	Error *FrameError `json:"-"`
+	// notices about the chat are shown apart from what members wrote
+	Notice bool `json:"-"`
 }
 
 type MessageEvent struct {
 	Type int
-	*Message
+	// written in this client, for Forward
+	Message *Message
+	// received from the server, for Incoming
+	Frame *Envelope
+}
+
+type ReadCursor struct {
+	Username  string     `json:"username"`
+	MessageID *int       `json:"message_id"`
+	ReadAt    *time.Time `json:"read_at"`
 }
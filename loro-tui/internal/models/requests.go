@@ -1,6 +1,27 @@
 package models
 
+import "time"
+
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
+
+// ChatState is how the user files a chat, muted until the time if set
+type ChatState struct {
+	Archived   bool       `json:"archived"`
+	Hidden     bool       `json:"hidden"`
+	MutedUntil *time.Time `json:"muted_until"`
+}
+
+// ChatStateUpdate changes how the user files a chat, fields left out stay as they are
+type ChatStateUpdate struct {
+	Archived   *bool      `json:"archived,omitempty"`
+	Hidden     *bool      `json:"hidden,omitempty"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+}
+
+type GroupRequest struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
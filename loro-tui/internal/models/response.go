@@ -1,6 +1,52 @@
 package models
 
+import "time"
+
 type LoginResponse struct {
 	Username string `json:"username,omitempty"`
 	Token    string `json:"token,omitempty"`
 }
+
+type MessagePage struct {
+	Messages   []*Message `json:"messages"`
+	NextCursor *string    `json:"next_cursor,omitempty"`
+}
+
+type SearchHit struct {
+	MessageID int       `json:"message_id"`
+	ChatID    int       `json:"chat_id"`
+	Seq       int64     `json:"seq"`
+	Sender    *string   `json:"sender"`
+	CreatedAt time.Time `json:"created_at"`
+	// matched words come inside <mark> tags
+	Snippet string `json:"snippet"`
+}
+
+type SearchPage struct {
+	Hits       []*SearchHit `json:"hits"`
+	NextCursor *string      `json:"next_cursor,omitempty"`
+}
+
+type BlockedUser struct {
+	Username  string    `json:"username"`
+	BlockedAt time.Time `json:"blocked_at"`
+}
+
+// ContactEntry is a contact of the user with the nickname given to them and their presence
+type ContactEntry struct {
+	Username   string     `json:"username"`
+	Nickname   *string    `json:"nickname,omitempty"`
+	Online     bool       `json:"online"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	Since      time.Time  `json:"since"`
+}
+
+type ContactRequest struct {
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ContactRequests struct {
+	Incoming []*ContactRequest `json:"incoming"`
+	Outgoing []*ContactRequest `json:"outgoing"`
+}
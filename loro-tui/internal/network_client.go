@@ -4,26 +4,42 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"loro-tui/internal/models"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	ws "loro-tui/internal/web_socket"
 )
 
+const maxReconnectDelay = 30 * time.Second
+
 type NetworkClient struct {
-	socketClient  *ws.SocketClient
-	httpClient    *http.Client
-	url           string
+	socketClient *ws.SocketClient
+	httpClient   *http.Client
+	url          string
+	// wire format asked from the server, it may answer with json instead
+	codec         string
 	MessageEvents chan *models.MessageEvent
 	ChatEvents    chan *models.ChatEvent
 	token         string
+	// guards socketClient which is replaced when reconnecting
+	mu      sync.Mutex
+	closing bool
 }
 
-func NewNetworkClient(url string) (*NetworkClient, error) {
+func NewNetworkClient(url, codec string) (*NetworkClient, error) {
 	client := http.Client{
 		Timeout: time.Second * 100,
 	}
@@ -39,28 +55,83 @@ func NewNetworkClient(url string) (*NetworkClient, error) {
 	return &NetworkClient{
 		httpClient:    &client,
 		url:           url,
+		codec:         codec,
 		MessageEvents: make(chan *models.MessageEvent),
 		ChatEvents:    make(chan *models.ChatEvent),
 	}, nil
 }
 
+/*
+AddListener reads frames from the server until the client is closed. When the
+server dies or goes away the listener reconnects, waiting longer after each
+failed attempt.
+*/
 func (c *NetworkClient) AddListener() {
-	defer func() {
-		c.socketClient.Close()
-	}()
-
 	for {
-		msg, err := c.socketClient.Listen()
+		frame, err := c.socket().Listen()
 		if err != nil {
+			if c.isClosing() {
+				return
+			}
 			log.Print(err)
+			c.MessageEvents <- &models.MessageEvent{Type: models.Disconnected}
+			if !c.reconnect() {
+				return
+			}
+			c.MessageEvents <- &models.MessageEvent{Type: models.Reconnected}
+			continue
 		}
-		msgSerialized := &models.Message{}
-		err = json.Unmarshal(msg, msgSerialized)
-		if err != nil {
-			log.Print(err)
+		if !frame.Known() {
+			// sent by a newer server, nothing this client can show
+			continue
 		}
 
-		c.MessageEvents <- &models.MessageEvent{Type: models.Incoming, Message: msgSerialized}
+		c.MessageEvents <- &models.MessageEvent{Type: models.Incoming, Frame: frame}
+	}
+}
+
+func (c *NetworkClient) reconnect() bool {
+	c.socket().Close()
+
+	delay := time.Second
+	for !c.isClosing() {
+		socket, err := ws.NewWSocketClient(c.url, c.token, device(), c.codec, true)
+		if err == nil {
+			c.mu.Lock()
+			c.socketClient = socket
+			c.mu.Unlock()
+			return true
+		}
+
+		time.Sleep(delay)
+		delay = min(2*delay, maxReconnectDelay)
+	}
+	return false
+}
+
+func (c *NetworkClient) socket() *ws.SocketClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.socketClient
+}
+
+func (c *NetworkClient) isClosing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closing
+}
+
+func (c *NetworkClient) Send(frame *models.Envelope) error {
+	return c.socket().Send(frame)
+}
+
+// Close leaves the websocket, the listener stops instead of reconnecting
+func (c *NetworkClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closing = true
+	if c.socketClient != nil {
+		c.socketClient.Close()
 	}
 }
 
@@ -83,7 +154,7 @@ func (c *NetworkClient) Login(payload models.LoginRequest) (*models.LoginRespons
 	}
 	c.token = loginResponse.Token
 
-	ws, err := ws.NewWSocketClient(c.url, loginResponse.Token)
+	ws, err := ws.NewWSocketClient(c.url, loginResponse.Token, device(), c.codec, false)
 	if err != nil {
 		return nil, err
 	}
@@ -98,7 +169,8 @@ func (c *NetworkClient) GetChats() ([]*models.Chat, error) {
 		"Cookie":       fmt.Sprintf("token=%s", c.token),
 	}
 
-	response, err := c.doRequest("GET", c.url+"/api/chats", nil, headers)
+	// archived and hidden chats too, the list filters them
+	response, err := c.doRequest("GET", c.url+"/api/chats?filter=all", nil, headers)
 	if err != nil {
 		return nil, err
 	}
@@ -111,24 +183,420 @@ func (c *NetworkClient) GetChats() ([]*models.Chat, error) {
 	return chatsResponse, nil
 }
 
-func (c *NetworkClient) GetMessages(chatID, limit, offset int) ([]*models.Message, error) {
+// GetMessages returns the newest messages of the chat, or the older ones the cursor of a page points to
+func (c *NetworkClient) GetMessages(chatID, limit int, cursor *string) (*models.MessagePage, error) {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+
+	query := url.Values{"limit": {strconv.Itoa(limit)}}
+	if cursor != nil {
+		query.Set("cursor", *cursor)
+	}
+	path := fmt.Sprintf("/api/%d/messages?%s", chatID, query.Encode())
+	response, err := c.doRequest("GET", c.url+path, nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	page := new(models.MessagePage)
+	err = json.Unmarshal(response, page)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// Upload sends a file to the chat and returns the attachment to send in a message
+func (c *NetworkClient) Upload(chatID int, path string) (*models.Attachment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{
+		"Content-Type": form.FormDataContentType(),
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+	response, err := c.doRequest("POST", c.url+fmt.Sprintf("/api/%d/attachments", chatID), body.Bytes(), headers)
+	if err != nil {
+		return nil, err
+	}
+	attachment := new(models.Attachment)
+	err = json.Unmarshal(response, attachment)
+	if err != nil {
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+/*
+Download saves the file of an attachment in the directory and returns its
+path. A file with the same name is never overwritten, a number is added to
+the name instead.
+*/
+func (c *NetworkClient) Download(attachment *models.Attachment, dir string) (string, error) {
+	req, err := http.NewRequest("GET", c.url+fmt.Sprintf("/api/attachments/%d", attachment.ID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Cookie", fmt.Sprintf("token=%s", c.token))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	name := filepath.Base(attachment.Filename)
+	ext := filepath.Ext(name)
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	for i := 1; errors.Is(err, fs.ErrExist); i++ {
+		path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext))
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", err
+	}
+	return path, file.Close()
+}
+
+// Search finds messages of the chats of the user, the query holds q and the optional filters
+func (c *NetworkClient) Search(query url.Values) (*models.SearchPage, error) {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+
+	response, err := c.doRequest("GET", c.url+"/api/search?"+query.Encode(), nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	page := new(models.SearchPage)
+	err = json.Unmarshal(response, page)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// GetPins returns the pinned messages of the chat, the latest pinned first
+func (c *NetworkClient) GetPins(chatID int) ([]*models.Pin, error) {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+
+	response, err := c.doRequest("GET", c.url+fmt.Sprintf("/api/%d/pins", chatID), nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	pins := make([]*models.Pin, 0)
+	err = json.Unmarshal(response, &pins)
+	if err != nil {
+		return nil, err
+	}
+
+	return pins, nil
+}
+
+// SetChatState archives, hides or mutes the chat for the user
+func (c *NetworkClient) SetChatState(chatID int, update models.ChatStateUpdate) (*models.ChatState, error) {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+	bytes, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.doRequest("PATCH", c.url+fmt.Sprintf("/api/%d/state", chatID), bytes, headers)
+	if err != nil {
+		return nil, err
+	}
+	updated := new(models.ChatState)
+	err = json.Unmarshal(response, updated)
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// GetContacts returns the contacts of the user with their presence
+func (c *NetworkClient) GetContacts() ([]*models.ContactEntry, error) {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+
+	response, err := c.doRequest("GET", c.url+"/api/contacts", nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	contacts := make([]*models.ContactEntry, 0)
+	err = json.Unmarshal(response, &contacts)
+	if err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// GetContactRequests returns the contact requests sent to the user and the ones they sent
+func (c *NetworkClient) GetContactRequests() (*models.ContactRequests, error) {
 	headers := map[string]string{
 		"Content-Type": "application/json",
 		"Cookie":       fmt.Sprintf("token=%s", c.token),
 	}
 
-	path := fmt.Sprintf("/api/%d/messages?limit=%d&offset=%d", chatID, limit, offset)
+	response, err := c.doRequest("GET", c.url+"/api/contacts/requests", nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	requests := new(models.ContactRequests)
+	err = json.Unmarshal(response, requests)
+	if err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// RequestContact asks the user to become a contact, accepting when they asked first
+func (c *NetworkClient) RequestContact(username string) error {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+	bytes, err := json.Marshal(map[string]string{"username": username})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.doRequest("POST", c.url+"/api/contacts/requests", bytes, headers)
+	return err
+}
+
+// AnswerContact accepts or declines the request of the user, declining a request sent withdraws it
+func (c *NetworkClient) AnswerContact(username string, accept bool) error {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+
+	path := "/api/contacts/requests/" + url.PathEscape(username)
+	if accept {
+		_, err := c.doRequest("POST", c.url+path+"/accept", nil, headers)
+		return err
+	}
+	_, err := c.doRequest("DELETE", c.url+path, nil, headers)
+	return err
+}
+
+// SetNickname names the contact for the user, an empty nickname removes it
+func (c *NetworkClient) SetNickname(username, nickname string) error {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+	bytes, err := json.Marshal(map[string]string{"nickname": nickname})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.doRequest("PUT", c.url+"/api/contacts/"+url.PathEscape(username), bytes, headers)
+	return err
+}
+
+func (c *NetworkClient) RemoveContact(username string) error {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+
+	_, err := c.doRequest("DELETE", c.url+"/api/contacts/"+url.PathEscape(username), nil, headers)
+	return err
+}
+
+// GetBlocks returns the users the user blocked, the latest first
+func (c *NetworkClient) GetBlocks() ([]*models.BlockedUser, error) {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+
+	response, err := c.doRequest("GET", c.url+"/api/blocks", nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make([]*models.BlockedUser, 0)
+	err = json.Unmarshal(response, &blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+// Block stops the user from writing to this one in a direct chat, unblocking lets them again
+func (c *NetworkClient) Block(username string, blocked bool) error {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+
+	if !blocked {
+		_, err := c.doRequest("DELETE", c.url+"/api/blocks/"+url.PathEscape(username), nil, headers)
+		return err
+	}
+	bytes, err := json.Marshal(map[string]string{"username": username})
+	if err != nil {
+		return err
+	}
+	_, err = c.doRequest("POST", c.url+"/api/blocks", bytes, headers)
+	return err
+}
+
+// GetMentions returns the mentions of the user not read yet, newest first
+func (c *NetworkClient) GetMentions() ([]*models.Mention, error) {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+
+	response, err := c.doRequest("GET", c.url+"/api/mentions", nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	mentions := make([]*models.Mention, 0)
+	err = json.Unmarshal(response, &mentions)
+	if err != nil {
+		return nil, err
+	}
+
+	return mentions, nil
+}
+
+// GetThread returns a page of the replies to a message, oldest first
+func (c *NetworkClient) GetThread(chatID, messageID, limit, offset int) ([]*models.Message, error) {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+
+	path := fmt.Sprintf("/api/%d/messages/%d/thread?limit=%d&offset=%d", chatID, messageID, limit, offset)
 	response, err := c.doRequest("GET", c.url+path, nil, headers)
 	if err != nil {
 		return nil, err
 	}
-	msgResponse := make([]*models.Message, 0)
-	err = json.Unmarshal(response, &msgResponse)
+	replies := make([]*models.Message, 0)
+	err = json.Unmarshal(response, &replies)
+	if err != nil {
+		return nil, err
+	}
+
+	return replies, nil
+}
+
+func (c *NetworkClient) GetReceipts(chatID int) ([]*models.ReadCursor, error) {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+
+	path := fmt.Sprintf("/api/%d/receipts", chatID)
+	response, err := c.doRequest("GET", c.url+path, nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	cursors := make([]*models.ReadCursor, 0)
+	err = json.Unmarshal(response, &cursors)
+	if err != nil {
+		return nil, err
+	}
+
+	return cursors, nil
+}
+
+func (c *NetworkClient) GetPresence(usernames []string) ([]*models.Presence, error) {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+
+	query := url.Values{"usernames": {strings.Join(usernames, ",")}}
+	response, err := c.doRequest("GET", c.url+"/api/presence?"+query.Encode(), nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	presence := make([]*models.Presence, 0)
+	err = json.Unmarshal(response, &presence)
+	if err != nil {
+		return nil, err
+	}
+
+	return presence, nil
+}
+
+func (c *NetworkClient) CreateGroup(payload models.GroupRequest) (*models.Chat, error) {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Cookie":       fmt.Sprintf("token=%s", c.token),
+	}
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.doRequest("POST", c.url+"/api/groups", bytes, headers)
+	if err != nil {
+		return nil, err
+	}
+	chat := new(models.Chat)
+	err = json.Unmarshal(response, chat)
 	if err != nil {
 		return nil, err
 	}
 
-	return msgResponse, nil
+	return chat, nil
+}
+
+// device names this terminal so the session can be told apart from others
+func device() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "loro-tui"
+	}
+	return "loro-tui@" + hostname
 }
 
 func (c *NetworkClient) doRequest(method, url string, body []byte, headers map[string]string) ([]byte, error) {
@@ -1,54 +1,139 @@
 package web_socket
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"loro-tui/internal/models"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	protocol "loro-protocol"
 
 	"github.com/gorilla/websocket"
 )
 
+// the server sends the id of the new session in this handshake header
+const sessionHeader = "X-Loro-Session"
+
+const (
+	// how often a server that does not tell pings
+	defaultPingInterval = 30 * time.Second
+	writeWait           = 10 * time.Second
+)
+
 type SocketClient struct {
-	conn             *websocket.Conn
-	IncomingMessages chan *models.Message
+	// frames are written from the event loop and the input field
+	writeMu sync.Mutex
+	conn    *websocket.Conn
+	codec   protocol.Codec
+	// without any frame or ping for this long the server is considered dead
+	timeout   time.Duration
+	SessionID string
 }
 
-func NewWSocketClient(url, token string) (*SocketClient, error) {
+// serverTimeout allows two and a half of the ping intervals the server advertised
+func serverTimeout(header http.Header) time.Duration {
+	interval := defaultPingInterval
+	if seconds, err := strconv.Atoi(header.Get(protocol.PingIntervalHeader)); err == nil && seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+	return interval * 5 / 2
+}
+
+/*
+NewWSocketClient joins the server as a new session asking for frames in the
+codec, "json" or "msgpack". A resuming client gets no live frames until it
+sent a resume frame and the replay ended.
+*/
+func NewWSocketClient(serverURL, token, device, codec string, resume bool) (*SocketClient, error) {
 
 	header := http.Header{}
 	header.Set("Cookie", fmt.Sprintf("token=%s", token))
 
-	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:8081/ws/join", header)
+	// http://host -> ws://host and https://host -> wss://host
+	query := url.Values{"device": {device}, protocol.VersionParam: {strconv.Itoa(protocol.Version)}}
+	wsURL := "ws" + strings.TrimPrefix(serverURL, "http") + "/ws/join?" + query.Encode()
+	if resume {
+		wsURL += "&resume=1"
+	}
+
+	// json stays acceptable when the server does not offer the codec
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{"loro." + codec, protocol.SubprotocolJSON}
+
+	conn, resp, err := dialer.Dial(wsURL, header)
 	if err != nil {
 		return nil, fmt.Errorf("websocket new client [%v]", err)
 	}
 
-	return &SocketClient{conn: conn}, nil
+	timeout := serverTimeout(resp.Header)
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPingHandler(func(data string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+
+	// a server that does not answer with a version only speaks the flat frames of version 0
+	if version, _ := strconv.Atoi(resp.Header.Get(protocol.VersionHeader)); version < 1 {
+		conn.Close()
+		return nil, fmt.Errorf("websocket new client [server does not speak protocol version %d]", protocol.Version)
+	}
+
+	return &SocketClient{
+		conn:      conn,
+		codec:     protocol.CodecFor(conn.Subprotocol()),
+		timeout:   timeout,
+		SessionID: resp.Header.Get(sessionHeader),
+	}, nil
 }
 
-func (ws *SocketClient) Send(message *models.Message) error {
-	bytes, err := json.Marshal(message)
+func (ws *SocketClient) Send(frame *models.Envelope) error {
+	bytes, err := ws.codec.Marshal(*frame)
 	if err != nil {
 		return fmt.Errorf("websocket fail writting bytes [%v]", err)
 	}
 
-	err = ws.conn.WriteMessage(websocket.TextMessage, bytes)
+	messageType := websocket.TextMessage
+	if ws.codec.Binary() {
+		messageType = websocket.BinaryMessage
+	}
+
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	err = ws.conn.WriteMessage(messageType, bytes)
 	if err != nil {
 		return fmt.Errorf("websocket send [%v]", err)
 	}
 	return nil
 }
 
-func (ws *SocketClient) Listen() ([]byte, error) {
+// Listen reads the next frame, one that cannot be decoded comes back empty
+func (ws *SocketClient) Listen() (*models.Envelope, error) {
 	_, message, err := ws.conn.ReadMessage()
 	if err != nil {
-		return nil, fmt.Errorf("websocket listen [%v]", err)
+		return nil, fmt.Errorf("websocket listen [%w]", err)
 	}
+	_ = ws.conn.SetReadDeadline(time.Now().Add(ws.timeout))
 
-	return message, nil
+	frame, err := ws.codec.Unmarshal(message)
+	if err != nil {
+		// the socket itself is fine, so this is no reason to reconnect
+		return &models.Envelope{}, nil
+	}
+	return frame, nil
 }
 
+// Close tells the server the client is leaving and closes the socket
 func (ws *SocketClient) Close() {
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	_ = ws.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
 	ws.conn.Close()
 }
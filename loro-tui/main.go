@@ -28,6 +28,7 @@ func main() {
 	}
 
 	url := flag.String("server", "", "Chat server url (required)")
+	codec := flag.String("codec", "json", "Wire format asked from the server: json or msgpack")
 	flag.Parse()
 
 	if *url == "" {
@@ -36,12 +37,13 @@ func main() {
 		os.Exit(1)
 	}
 
-	loro, err := internal.NewLoro(*url, logger)
+	loro, err := internal.NewLoro(*url, *codec, logger)
 	if err != nil {
 		fmt.Println("Server: " + err.Error())
 		os.Exit(1)
 	}
 
+	defer loro.NetworkClient.Close()
 	if err := loro.SetRoot(internal.Pages, true).EnableMouse(true).Run(); err != nil {
 		panic(err)
 	}